@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/opsgenie/opsgenie-go-sdk-v2/client"
+	"github.com/opsgenie/opsgenie-go-sdk-v2/heartbeat"
+)
+
+// AlertAPI represents the OpsGenie alert operations used by the handler
+type AlertAPI interface {
+	Create(ctx context.Context, req *alert.CreateAlertRequest) (*alert.AsyncAlertResult, error)
+	Get(ctx context.Context, req *alert.GetAlertRequest) (*alert.GetAlertResult, error)
+	Close(ctx context.Context, req *alert.CloseAlertRequest) (*alert.AsyncAlertResult, error)
+	AddNote(ctx context.Context, req *alert.AddNoteRequest) (*alert.AsyncAlertResult, error)
+	Acknowledge(ctx context.Context, req *alert.AcknowledgeAlertRequest) (*alert.AsyncAlertResult, error)
+	Snooze(ctx context.Context, req *alert.SnoozeAlertRequest) (*alert.AsyncAlertResult, error)
+	AddDetails(ctx context.Context, req *alert.AddDetailsRequest) (*alert.AsyncAlertResult, error)
+	AddResponder(ctx context.Context, req *alert.AddResponderRequest) (*alert.AsyncAlertResult, error)
+	AddTags(ctx context.Context, req *alert.AddTagsRequest) (*alert.AsyncAlertResult, error)
+	UpdatePriority(ctx context.Context, req *alert.UpdatePriorityRequest) (*alert.AsyncAlertResult, error)
+	UpdateMessage(ctx context.Context, req *alert.UpdateMessageRequest) (*alert.AsyncAlertResult, error)
+	UpdateDescription(ctx context.Context, req *alert.UpdateDescriptionRequest) (*alert.AsyncAlertResult, error)
+	GetRequestStatus(ctx context.Context, req *alert.GetRequestStatusRequest) (*alert.RequestStatusResult, error)
+}
+
+// HeartbeatAPI represents the OpsGenie heartbeat operations used by the handler
+type HeartbeatAPI interface {
+	Ping(ctx context.Context, heartbeatName string) (*heartbeat.PingResult, error)
+}
+
+var (
+	// newAlertClient func creates the alert client used by executeHandler, tests can replace it
+	newAlertClient = func(config *client.Config) (AlertAPI, error) {
+		alertClient, err := alert.NewClient(config)
+		if err != nil {
+			return nil, err
+		}
+		return alertClient, nil
+	}
+
+	// newHeartbeatClient func creates the heartbeat client used by executeHandler, tests can replace it
+	newHeartbeatClient = func(config *client.Config) (HeartbeatAPI, error) {
+		heartbeatClient, err := heartbeat.NewClient(config)
+		if err != nil {
+			return nil, err
+		}
+		return heartbeatClient, nil
+	}
+)
+
+// opsgenieConfig func returns the OpsGenie client configuration shared by alert and heartbeat clients
+// of the ctx target
+func opsgenieConfig(ctx context.Context) (*client.Config, error) {
+	t := currentTarget(ctx)
+	httpClient, err := httpClient()
+	if err != nil {
+		return nil, err
+	}
+	return &client.Config{
+		ApiKey:         t.token,
+		OpsGenieAPIURL: opsgenieRegion(t.region),
+		HttpClient:     httpClient,
+		RetryPolicy:    noRetryPolicy,
+		RequestTimeout: callTimeout(),
+	}, nil
+}
+
+// targetAlertClient func returns the alert client created for the ctx target by fanOut, or a new one
+func targetAlertClient(ctx context.Context) (AlertAPI, error) {
+	if t := currentTarget(ctx); t.alertClient != nil {
+		return t.alertClient, nil
+	}
+	config, err := opsgenieConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newAlertClient(config)
+}
+
+// targetHeartbeatClient func returns the heartbeat client created for the ctx target by fanOut, or a new one
+func targetHeartbeatClient(ctx context.Context) (HeartbeatAPI, error) {
+	if t := currentTarget(ctx); t.heartbeatClient != nil {
+		return t.heartbeatClient, nil
+	}
+	config, err := opsgenieConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newHeartbeatClient(config)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// backends accepted by --backend
+const (
+	backendOpsgenie = "opsgenie"
+	backendJSM      = "jsm"
+)
+
+// jsmBaseURL is the Jira Service Management Operations integration API, it accepts OpsGenie v2 alert
+// and heartbeat requests under this prefix, authenticated only with the GenieKey of the integration
+const jsmBaseURL = "https://api.atlassian.com/jsm/ops/integration"
+
+// backend func returns --backend in lower case, empty means opsgenie
+func backend() string {
+	if plugin.Backend == "" {
+		return backendOpsgenie
+	}
+	return strings.ToLower(plugin.Backend)
+}
+
+// checkBackend func validates --backend
+func checkBackend() error {
+	switch backend() {
+	case backendOpsgenie, backendJSM:
+	default:
+		return fmt.Errorf("invalid --backend %s: use %s or %s", plugin.Backend, backendOpsgenie, backendJSM)
+	}
+	return nil
+}
+
+// backendBaseURL func returns the base URL used when --api-url is empty, opsgenie uses --region instead
+func backendBaseURL() string {
+	if backend() == backendJSM {
+		return jsmBaseURL
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// useBackend sets --backend until the test ends
+func useBackend(t *testing.T, name string) {
+	plugin.Backend = name
+	t.Cleanup(func() {
+		plugin.Backend = ""
+	})
+}
+
+func TestCheckBackend(t *testing.T) {
+	testCases := []struct {
+		backend       string
+		expectedError bool
+	}{
+		{"", false},
+		{"opsgenie", false},
+		{"JSM", false},
+		{"jsm", false},
+		{"pagerduty", true},
+	}
+	for _, tc := range testCases {
+		useBackend(t, tc.backend)
+		err := checkBackend()
+		if tc.expectedError {
+			assert.Error(t, err, tc.backend)
+		} else {
+			assert.NoError(t, err, tc.backend)
+		}
+	}
+}
+
+func TestJSMBaseURL(t *testing.T) {
+	useBackend(t, backendJSM)
+	base, ok, err := apiBaseURL()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, jsmBaseURL, base.String())
+	assert.Equal(t, "api.atlassian.com", string(switchOpsgenieRegion()))
+
+	// --api-url still wins
+	plugin.APIURL = "https://gateway.example.com/jsm"
+	defer func() { plugin.APIURL = "" }()
+	base, _, err = apiBaseURL()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://gateway.example.com/jsm", base.String())
+}
+
+func TestJSMBackendEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	gateway := httptest.NewServer(http.StripPrefix("/jsm/ops/integration", server.Handler()))
+	defer gateway.Close()
+	plugin.APIURL = gateway.URL + "/jsm/ops/integration"
+	useBackend(t, backendJSM)
+	event := types.FixtureEvent("entity1", "check1")
+	event.Check.Status = 2
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, executeHandler(event))
+	created, ok := server.Alert("entity1/check1")
+	assert.True(t, ok)
+	assert.Equal(t, "P3", created.Priority)
+
+	event.Check.Status = 0
+	assert.NoError(t, executeHandler(event))
+	closed, _ := server.Alert("entity1/check1")
+	assert.Equal(t, "closed", closed.Status)
+	for _, request := range server.Requests() {
+		assert.Equal(t, "GenieKey mock-token", request.Authorization, request.Path)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// businessWindow represents one entry of --business-hours, start and end are minutes since midnight
+type businessWindow struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+// businessHours represents --business-hours, --business-hours-timezone and --business-hours-holidays
+type businessHours struct {
+	windows  []businessWindow
+	location *time.Location
+	holidays map[string]bool
+}
+
+// loadedBusinessHours keeps the business hours read by offHours, --business-hours-holidays is read once per handler run.
+// Targets of --targets use it concurrently, the mutex guards the cache
+var loadedBusinessHours struct {
+	sync.Mutex
+	config string
+	hours  *businessHours
+	err    error
+}
+
+// resetBusinessHours func forgets the business hours read by a previous handler run
+func resetBusinessHours() {
+	loadedBusinessHours.Lock()
+	defer loadedBusinessHours.Unlock()
+	loadedBusinessHours.config, loadedBusinessHours.hours, loadedBusinessHours.err = "", nil, nil
+}
+
+// cachedBusinessHours func returns the business hours read by this handler run, it reads them
+// and warns about an invalid configuration the first time
+func cachedBusinessHours() (*businessHours, error) {
+	loadedBusinessHours.Lock()
+	defer loadedBusinessHours.Unlock()
+	config := strings.Join([]string{plugin.BusinessHours, plugin.BusinessHoursTimezone, plugin.BusinessHoursHolidays, plugin.OffHoursPriority}, "|")
+	if (loadedBusinessHours.hours != nil || loadedBusinessHours.err != nil) && loadedBusinessHours.config == config {
+		return loadedBusinessHours.hours, loadedBusinessHours.err
+	}
+	hours, err := loadBusinessHours()
+	if err != nil {
+		fmt.Printf("[WARN] Ignoring business hours: %s \n", err)
+	}
+	loadedBusinessHours.config, loadedBusinessHours.hours, loadedBusinessHours.err = config, hours, err
+	return hours, err
+}
+
+// loadBusinessHours func reads business hours configuration from plugin config
+func loadBusinessHours() (*businessHours, error) {
+	windows, err := parseBusinessWindows(plugin.BusinessHours)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(plugin.BusinessHoursTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("business hours invalid timezone %q: %s", plugin.BusinessHoursTimezone, err)
+	}
+	holidays := make(map[string]bool)
+	if plugin.BusinessHoursHolidays != "" {
+		holidays, err = readHolidays(plugin.BusinessHoursHolidays)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := parsePriority(plugin.OffHoursPriority); err != nil && plugin.OffHoursPriority != "" {
+		return nil, fmt.Errorf("off hours invalid priority %q: %s", plugin.OffHoursPriority, err)
+	}
+	return &businessHours{windows: windows, location: location, holidays: holidays}, nil
+}
+
+// parseBusinessWindows func parses Mon-Fri 09:00-18:00,Sat 10:00-14:00 in a list of businessWindow
+func parseBusinessWindows(s string) ([]businessWindow, error) {
+	windows := []businessWindow{}
+	for _, v := range splitStringInSlice(s) {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		fields := strings.Fields(v)
+		if len(fields) != 2 {
+			return windows, fmt.Errorf("business hours wrong format %q: Mon-Fri 09:00-18:00", v)
+		}
+		window := businessWindow{}
+		if err := parseWeekdays(fields[0], &window.days); err != nil {
+			return windows, err
+		}
+		start, end := splitString(fields[1], "-")
+		var err error
+		if window.start, err = parseClock(start); err != nil {
+			return windows, fmt.Errorf("business hours %s", err)
+		}
+		if window.end, err = parseClock(end); err != nil {
+			return windows, fmt.Errorf("business hours %s", err)
+		}
+		if window.start >= window.end {
+			return windows, fmt.Errorf("business hours %q should start before it ends", v)
+		}
+		windows = append(windows, window)
+	}
+	if len(windows) == 0 {
+		return windows, fmt.Errorf("business hours is empty")
+	}
+	return windows, nil
+}
+
+// parseWeekdays func parses Mon, Mon-Fri or Fri-Mon and marks them in days
+func parseWeekdays(s string, days *[7]bool) error {
+	first, last := splitString(s, "-")
+	if first == "" {
+		first, last = s, s
+	}
+	from, ok := weekdays[strings.ToLower(first)]
+	if !ok {
+		return fmt.Errorf("business hours invalid weekday %q", first)
+	}
+	to, ok := weekdays[strings.ToLower(last)]
+	if !ok {
+		return fmt.Errorf("business hours invalid weekday %q", last)
+	}
+	for day := from; ; day = (day + 1) % 7 {
+		days[day] = true
+		if day == to {
+			break
+		}
+	}
+	return nil
+}
+
+// parseClock func parses 09:00 in minutes since midnight, 24:00 is allowed as end of day
+func parseClock(s string) (int, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(s, "%d:%d", &hour, &minute); err != nil {
+		return 0, fmt.Errorf("invalid time %q: HH:MM", s)
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time %q: HH:MM", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// readHolidays func reads a file with one YYYY-MM-DD date per line, lines starting with # are ignored
+func readHolidays(file string) (map[string]bool, error) {
+	holidays := make(map[string]bool)
+	f, err := os.Open(file)
+	if err != nil {
+		return holidays, fmt.Errorf("business hours holidays: %s", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", line); err != nil {
+			return holidays, fmt.Errorf("business hours invalid holiday %q: YYYY-MM-DD", line)
+		}
+		holidays[line] = true
+	}
+	return holidays, scanner.Err()
+}
+
+// isBusinessHours func returns true if t is inside one window and is not a holiday
+func (b *businessHours) isBusinessHours(t time.Time) bool {
+	local := t.In(b.location)
+	if b.holidays[local.Format("2006-01-02")] {
+		return false
+	}
+	minutes := local.Hour()*60 + local.Minute()
+	for _, window := range b.windows {
+		if window.days[local.Weekday()] && minutes >= window.start && minutes < window.end {
+			return true
+		}
+	}
+	return false
+}
+
+// isNonCritical func returns true if check or entity has the --non-critical-label
+func isNonCritical(event *types.Event) bool {
+	key, value := splitString(plugin.NonCriticalLabel, "=")
+	if key == "" {
+		return false
+	}
+	if event.Check != nil && event.Check.Labels != nil && event.Check.Labels[key] == value {
+		return true
+	}
+	if event.Entity != nil && event.Entity.Labels != nil && event.Entity.Labels[key] == value {
+		return true
+	}
+	return false
+}
+
+// offHours func lowers priority and replaces responders for non-critical checks outside business hours at the event time,
+// with nil teams only the priority is changed, like when an open alert is updated
+func offHours(event *types.Event, priority alert.Priority, teams []alert.Responder) (alert.Priority, []alert.Responder) {
+	if !isNonCritical(event) {
+		return priority, teams
+	}
+	hours, err := cachedBusinessHours()
+	if err != nil {
+		return priority, teams
+	}
+	if hours.isBusinessHours(eventTime(event)) {
+		return priority, teams
+	}
+	if offPriority, err := parsePriority(plugin.OffHoursPriority); err == nil && priorityRank(offPriority) > priorityRank(priority) {
+		fmt.Printf("Outside business hours: lowering priority from %s to %s \n", priority, offPriority)
+		priority = offPriority
+	}
+	if plugin.OffHoursTeam != "" && teams != nil {
+		offTeams := []alert.Responder{}
+		for _, v := range splitStringInSlice(plugin.OffHoursTeam) {
+			if v != "" {
+				offTeams = append(offTeams, alert.Responder{Type: alert.TeamResponder, Name: v})
+			}
+		}
+		fmt.Printf("Outside business hours: responders changed to %s \n", plugin.OffHoursTeam)
+		teams = offTeams
+	}
+	return priority, teams
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBusinessWindows(t *testing.T) {
+	windows, err := parseBusinessWindows("Mon-Fri 09:00-18:00,sat 10:00-14:30")
+	assert.NoError(t, err)
+	assert.Len(t, windows, 2)
+	assert.True(t, windows[0].days[time.Monday])
+	assert.True(t, windows[0].days[time.Friday])
+	assert.False(t, windows[0].days[time.Saturday])
+	assert.Equal(t, 9*60, windows[0].start)
+	assert.Equal(t, 18*60, windows[0].end)
+	assert.True(t, windows[1].days[time.Saturday])
+	assert.Equal(t, 14*60+30, windows[1].end)
+
+	// ranges can wrap the week
+	windows, err = parseBusinessWindows("Fri-Mon 00:00-24:00")
+	assert.NoError(t, err)
+	assert.True(t, windows[0].days[time.Sunday])
+	assert.False(t, windows[0].days[time.Wednesday])
+
+	for _, invalid := range []string{"", "Mon-Fri", "Moon 09:00-18:00", "Mon 18:00-09:00", "Mon 9-18", "Mon 09:00-25:00"} {
+		_, err = parseBusinessWindows(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestIsBusinessHours(t *testing.T) {
+	plugin.BusinessHours = "Mon-Fri 09:00-18:00"
+	plugin.BusinessHoursTimezone = "Europe/Berlin"
+	plugin.BusinessHoursHolidays = ""
+	plugin.OffHoursPriority = "P5"
+	defer func() { plugin.BusinessHours = "" }()
+	hours, err := loadBusinessHours()
+	assert.NoError(t, err)
+	// 2021-08-02 is a Monday, 08:30 UTC is 10:30 in Berlin
+	assert.True(t, hours.isBusinessHours(time.Date(2021, 8, 2, 8, 30, 0, 0, time.UTC)))
+	// 17:00 UTC is 19:00 in Berlin
+	assert.False(t, hours.isBusinessHours(time.Date(2021, 8, 2, 17, 0, 0, 0, time.UTC)))
+	// Saturday
+	assert.False(t, hours.isBusinessHours(time.Date(2021, 8, 7, 10, 0, 0, 0, time.UTC)))
+
+	dir, err := ioutil.TempDir("", "holidays")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "holidays.txt")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("# company holidays\n2021-08-02\n"), 0644))
+	plugin.BusinessHoursHolidays = file
+	defer func() { plugin.BusinessHoursHolidays = "" }()
+	hours, err = loadBusinessHours()
+	assert.NoError(t, err)
+	assert.False(t, hours.isBusinessHours(time.Date(2021, 8, 2, 8, 30, 0, 0, time.UTC)))
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte("02/08/2021\n"), 0644))
+	_, err = loadBusinessHours()
+	assert.Error(t, err)
+
+	plugin.BusinessHoursHolidays = ""
+	plugin.BusinessHoursTimezone = "Mars/Olympus"
+	_, err = loadBusinessHours()
+	assert.Error(t, err)
+	plugin.BusinessHoursTimezone = "UTC"
+}
+
+func TestOffHours(t *testing.T) {
+	plugin.BusinessHours = "Mon-Fri 09:00-18:00"
+	plugin.BusinessHoursTimezone = "UTC"
+	plugin.BusinessHoursHolidays = ""
+	plugin.OffHoursPriority = "P5"
+	plugin.OffHoursTeam = "batch-owners"
+	plugin.NonCriticalLabel = "opsgenie_non_critical=true"
+	defer func() {
+		plugin.BusinessHours = ""
+		plugin.OffHoursTeam = ""
+		now = time.Now
+	}()
+	teams := []alert.Responder{{Type: alert.TeamResponder, Name: "sre"}}
+	event := types.FixtureEvent("foo", "bar")
+
+	// 03:00 on a Tuesday, the handler runs at 10:00 but the event time is used
+	event.Timestamp = time.Date(2021, 8, 3, 3, 0, 0, 0, time.UTC).Unix()
+	now = func() time.Time { return time.Date(2021, 8, 3, 10, 0, 0, 0, time.UTC) }
+
+	// critical checks are not changed
+	priority, responders := offHours(event, alert.P3, teams)
+	assert.Equal(t, alert.P3, priority)
+	assert.Equal(t, teams, responders)
+
+	event.Check.Labels = map[string]string{"opsgenie_non_critical": "true"}
+	priority, responders = offHours(event, alert.P3, teams)
+	assert.Equal(t, alert.P5, priority)
+	assert.Equal(t, []alert.Responder{{Type: alert.TeamResponder, Name: "batch-owners"}}, responders)
+
+	// priority is never raised
+	plugin.OffHoursPriority = "P2"
+	priority, _ = offHours(event, alert.P3, teams)
+	assert.Equal(t, alert.P3, priority)
+	plugin.OffHoursPriority = "P5"
+
+	// inside business hours nothing changes
+	event.Timestamp = time.Date(2021, 8, 3, 10, 0, 0, 0, time.UTC).Unix()
+	priority, responders = offHours(event, alert.P3, teams)
+	assert.Equal(t, alert.P3, priority)
+	assert.Equal(t, teams, responders)
+
+	// events without timestamp use the handler time
+	event.Timestamp = 0
+	now = func() time.Time { return time.Date(2021, 8, 3, 3, 0, 0, 0, time.UTC) }
+	priority, _ = offHours(event, alert.P3, teams)
+	assert.Equal(t, alert.P5, priority)
+}
+
+func TestOffHoursReadOnce(t *testing.T) {
+	plugin.BusinessHours = "Mon-Fri 09:00-18:00"
+	plugin.BusinessHoursTimezone = "UTC"
+	plugin.OffHoursPriority = "P5"
+	plugin.NonCriticalLabel = "opsgenie_non_critical=true"
+	plugin.BusinessHoursHolidays = filepath.Join(t.TempDir(), "holidays.txt")
+	defer func() {
+		plugin.BusinessHours = ""
+		plugin.BusinessHoursHolidays = ""
+		resetBusinessHours()
+	}()
+	assert.NoError(t, ioutil.WriteFile(plugin.BusinessHoursHolidays, []byte("2021-08-03\n"), 0600))
+	event := types.FixtureEvent("foo", "bar")
+	event.Check.Labels = map[string]string{"opsgenie_non_critical": "true"}
+	// 10:00 on a holiday
+	event.Timestamp = time.Date(2021, 8, 3, 10, 0, 0, 0, time.UTC).Unix()
+	priority, _ := offHours(event, alert.P3, nil)
+	assert.Equal(t, alert.P5, priority)
+
+	// the holidays file is not read again during the handler run
+	assert.NoError(t, os.Remove(plugin.BusinessHoursHolidays))
+	priority, _ = offHours(event, alert.P3, nil)
+	assert.Equal(t, alert.P5, priority)
+
+	// the next handler run reads it again, business hours are ignored when it is missing
+	resetBusinessHours()
+	priority, _ = offHours(event, alert.P3, nil)
+	assert.Equal(t, alert.P3, priority)
+}
+
+func TestIsNonCritical(t *testing.T) {
+	plugin.NonCriticalLabel = "criticality=low"
+	defer func() { plugin.NonCriticalLabel = "opsgenie_non_critical=true" }()
+	event := types.FixtureEvent("foo", "bar")
+	assert.False(t, isNonCritical(event))
+	event.Entity.Labels = map[string]string{"criticality": "low"}
+	assert.True(t, isNonCritical(event))
+	plugin.NonCriticalLabel = ""
+	assert.False(t, isNonCritical(event))
+}
+
+func TestOffHoursStatusChange(t *testing.T) {
+	plugin.BusinessHours = "Mon-Fri 09:00-18:00"
+	plugin.BusinessHoursTimezone = "UTC"
+	plugin.OffHoursPriority = "P5"
+	plugin.OffHoursTeam = "batch-owners"
+	plugin.NonCriticalLabel = "opsgenie_non_critical=true"
+	plugin.StatusPriorityMap = "1=P3,2=P1"
+	defer func() {
+		plugin.BusinessHours = ""
+		plugin.OffHoursTeam = ""
+		plugin.StatusPriorityMap = ""
+		now = time.Now
+	}()
+	alertAPI := &fakeAlertAPI{}
+	openAlert := &alert.GetAlertResult{Id: "alert-id", Status: "open", Priority: alert.P5, Details: map[string]string{"status": "1"}}
+	event := types.FixtureEvent("foo", "bar")
+	// 03:00 on a Tuesday
+	event.Timestamp = time.Date(2021, 8, 3, 3, 0, 0, 0, time.UTC).Unix()
+	event.Check.Status = 2
+	event.Check.Labels = map[string]string{"opsgenie_non_critical": "true"}
+
+	// warning to critical at night keeps the off hours priority and does not change responders
+	assert.NoError(t, updateStatusChange(context.Background(), alertAPI, event, openAlert, 1))
+	assert.NotContains(t, alertAPI.calls, "UpdatePriority alert-id P1")
+	assert.Equal(t, alert.P5, openAlert.Priority)
+	for _, call := range alertAPI.calls {
+		assert.NotContains(t, call, "AddResponder")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// default deadlines used when --timeout or --call-timeout cannot be parsed
+const (
+	defaultTimeout     = 9 * time.Second
+	defaultCallTimeout = 5 * time.Second
+)
+
+// parseTimeout func parses a positive duration option like --timeout
+func parseTimeout(name, value string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --%s %q: %s", name, value, err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("invalid --%s %q: should be greater than zero", name, value)
+	}
+	return timeout, nil
+}
+
+// checkTimeouts func validates --timeout and --call-timeout, empty values use the defaults
+func checkTimeouts() error {
+	if plugin.Timeout != "" {
+		if _, err := parseTimeout("timeout", plugin.Timeout); err != nil {
+			return err
+		}
+	}
+	if plugin.CallTimeout != "" {
+		if _, err := parseTimeout("call-timeout", plugin.CallTimeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handlerTimeout func returns --timeout, the budget of a whole handler run
+func handlerTimeout() time.Duration {
+	timeout, err := parseTimeout("timeout", plugin.Timeout)
+	if err != nil {
+		return defaultTimeout
+	}
+	return timeout
+}
+
+// callTimeout func returns --call-timeout, the timeout of each OpsGenie API call
+func callTimeout() time.Duration {
+	timeout, err := parseTimeout("call-timeout", plugin.CallTimeout)
+	if err != nil {
+		return defaultCallTimeout
+	}
+	return timeout
+}
+
+// handlerContext func returns the context of a handler run, it is done when --timeout is reached
+func handlerContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), handlerTimeout())
+}
+
+// callWithTimeout func calls fn with a context limited by --call-timeout and the time left of the handler run.
+// OpsGenie SDK does not cancel http requests with the context, the http client timeout limits each call
+// and a call still running when the handler deadline is reached is abandoned
+func callWithTimeout(ctx context.Context, fn func(ctx context.Context) error) error {
+	callCtx, cancel := context.WithTimeout(ctx, callTimeout())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(callCtx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deadlineError func returns an error naming the step that did not finish before --timeout
+func deadlineError(step string, err error) error {
+	return fmt.Errorf("handler timeout of %s reached before %s finished: %w", handlerTimeout(), step, err)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimeout(t *testing.T) {
+	timeout, err := parseTimeout("timeout", "9s")
+	assert.NoError(t, err)
+	assert.Equal(t, 9*time.Second, timeout)
+	_, err = parseTimeout("timeout", "nine")
+	assert.Error(t, err)
+	_, err = parseTimeout("call-timeout", "0s")
+	assert.Error(t, err)
+
+	plugin.Timeout = "-1s"
+	assert.Error(t, checkTimeouts())
+	assert.Equal(t, defaultTimeout, handlerTimeout())
+	plugin.Timeout = ""
+	assert.NoError(t, checkTimeouts())
+	assert.Equal(t, defaultCallTimeout, callTimeout())
+}
+
+func TestCallWithTimeout(t *testing.T) {
+	plugin.CallTimeout = "20ms"
+	defer func() { plugin.CallTimeout = "" }()
+	ctx := context.Background()
+
+	// each call has --call-timeout
+	err := callWithTimeout(ctx, func(ctx context.Context) error {
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.True(t, time.Until(deadline) <= 20*time.Millisecond)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// a call that ignores its context is abandoned at the handler deadline
+	handlerCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = callWithTimeout(handlerCtx, func(ctx context.Context) error {
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+)
+
+// escalationDetail is the alert detail used to save the last escalation rule applied
+const escalationDetail = "escalation_occurrences"
+
+// escalationRule represents one entry of --escalation-rules
+type escalationRule struct {
+	occurrences int64
+	priority    alert.Priority
+	teams       []string
+}
+
+// parseEscalationRules func parses 5=P2:sre-escalation,10=P1:sre-managers:ops in a list of escalationRule
+// sorted by occurrences
+func parseEscalationRules(s string) ([]escalationRule, error) {
+	rules := []escalationRule{}
+	for _, v := range splitStringInSlice(s) {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		key, value := splitString(v, "=")
+		if key == "" || value == "" {
+			return rules, fmt.Errorf("escalation rule wrong format %q: occurrences=priority:team", v)
+		}
+		occurrences, err := strconv.ParseInt(strings.TrimSpace(key), 10, 64)
+		if err != nil || occurrences < 1 {
+			return rules, fmt.Errorf("escalation rule invalid occurrences %q: should be a number greater than 0", key)
+		}
+		rule := escalationRule{occurrences: occurrences}
+		fields := strings.Split(value, ":")
+		if fields[0] != "" {
+			priority, err := parsePriority(fields[0])
+			if err != nil {
+				return rules, fmt.Errorf("escalation rule invalid priority %q: %s", fields[0], err)
+			}
+			rule.priority = priority
+		}
+		for _, team := range fields[1:] {
+			if team != "" {
+				rule.teams = append(rule.teams, team)
+			}
+		}
+		if rule.priority == "" && len(rule.teams) == 0 {
+			return rules, fmt.Errorf("escalation rule %q should have a priority or a team", v)
+		}
+		rules = append(rules, rule)
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].occurrences < rules[j].occurrences
+	})
+	return rules, nil
+}
+
+// matchEscalationRule func returns the rule with the highest occurrences lower or equal than occurrences
+func matchEscalationRule(rules []escalationRule, occurrences int64) (escalationRule, bool) {
+	var (
+		found bool
+		match escalationRule
+	)
+	for _, rule := range rules {
+		if rule.occurrences <= occurrences {
+			match = rule
+			found = true
+		}
+	}
+	return match, found
+}
+
+// priorityRank func returns 1 for P1 until 5 for P5, lower is more urgent
+func priorityRank(priority alert.Priority) int {
+	rank, err := strconv.Atoi(strings.TrimPrefix(string(priority), "P"))
+	if err != nil {
+		return 6
+	}
+	return rank
+}
+
+// escalationDue func returns true if an --escalation-rules entry matches the check occurrences
+func escalationDue(event *types.Event) bool {
+	if plugin.EscalationRules == "" {
+		return false
+	}
+	rules, err := parseEscalationRules(plugin.EscalationRules)
+	if err != nil {
+		return false
+	}
+	_, ok := matchEscalationRule(rules, event.Check.Occurrences)
+	return ok
+}
+
+// escalatedPriority func returns the more urgent of priority and the priority of the --escalation-rules entry
+// matching the check occurrences, so an escalated alert is not lowered when it is updated
+func escalatedPriority(event *types.Event, priority alert.Priority) alert.Priority {
+	if plugin.EscalationRules == "" {
+		return priority
+	}
+	rules, err := parseEscalationRules(plugin.EscalationRules)
+	if err != nil {
+		return priority
+	}
+	rule, ok := matchEscalationRule(rules, event.Check.Occurrences)
+	if !ok || rule.priority == "" || priorityRank(rule.priority) >= priorityRank(priority) {
+		return priority
+	}
+	return rule.priority
+}
+
+// escalateAlert func raises priority and adds responders to the alert that was open before the event,
+// as returned by incidentEvent, using --escalation-rules
+func escalateAlert(ctx context.Context, alertClient AlertAPI, event *types.Event, openAlert *alert.GetAlertResult) error {
+	rules, err := parseEscalationRules(plugin.EscalationRules)
+	if err != nil {
+		return err
+	}
+	rule, ok := matchEscalationRule(rules, event.Check.Occurrences)
+	if !ok {
+		return nil
+	}
+	if openAlert == nil {
+		_, alias, _ := parseEventKeyTags(event)
+		fmt.Printf("Not escalating %s: no open alert found \n", alias)
+		return nil
+	}
+
+	// priority is only raised, never lowered
+	if rule.priority != "" && priorityRank(rule.priority) < priorityRank(openAlert.Priority) {
+		var priorityResult *alert.AsyncAlertResult
+		err := withRetry(ctx, "escalate priority of alert "+openAlert.Id, func(ctx context.Context) (err error) {
+			priorityResult, err = alertClient.UpdatePriority(ctx, &alert.UpdatePriorityRequest{
+				IdentifierType:  alert.ALERTID,
+				IdentifierValue: openAlert.Id,
+				Priority:        rule.priority,
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("RequestID %s to escalate priority %s to %s after %d occurrences \n", priorityResult.RequestId, openAlert.Id, rule.priority, event.Check.Occurrences)
+	}
+
+	// responders are added once per rule
+	applied, _ := strconv.ParseInt(openAlert.Details[escalationDetail], 10, 64)
+	if applied >= rule.occurrences {
+		return nil
+	}
+	for _, team := range rule.teams {
+		var responderResult *alert.AsyncAlertResult
+		err := withRetry(ctx, "add responder "+team+" to alert "+openAlert.Id, func(ctx context.Context) (err error) {
+			responderResult, err = alertClient.AddResponder(ctx, &alert.AddResponderRequest{
+				IdentifierType:  alert.ALERTID,
+				IdentifierValue: openAlert.Id,
+				Responder:       alert.Responder{Type: alert.TeamResponder, Name: team},
+				Source:          source,
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("RequestID %s to add responder %s to %s \n", responderResult.RequestId, team, openAlert.Id)
+	}
+	details := map[string]string{escalationDetail: fmt.Sprintf("%d", rule.occurrences)}
+	notes := fmt.Sprintf("Escalated after %d occurrences", event.Check.Occurrences)
+	return updateAlert(ctx, alertClient, notes, openAlert.Id, details)
+}
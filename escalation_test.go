@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEscalationRules(t *testing.T) {
+	rules, err := parseEscalationRules("10=P1:sre-managers:ops,5=P2:sre-escalation,20=:directors")
+	assert.NoError(t, err)
+	expected := []escalationRule{
+		{occurrences: 5, priority: alert.P2, teams: []string{"sre-escalation"}},
+		{occurrences: 10, priority: alert.P1, teams: []string{"sre-managers", "ops"}},
+		{occurrences: 20, teams: []string{"directors"}},
+	}
+	assert.Equal(t, expected, rules)
+
+	rules, err = parseEscalationRules("")
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+
+	_, err = parseEscalationRules("five=P2")
+	assert.Error(t, err)
+	_, err = parseEscalationRules("0=P2")
+	assert.Error(t, err)
+	_, err = parseEscalationRules("5=P0:sre")
+	assert.Error(t, err)
+	_, err = parseEscalationRules("5=:")
+	assert.Error(t, err)
+}
+
+func TestMatchEscalationRule(t *testing.T) {
+	rules, err := parseEscalationRules("5=P2:sre-escalation,10=P1")
+	assert.NoError(t, err)
+	_, ok := matchEscalationRule(rules, 4)
+	assert.False(t, ok)
+	rule, ok := matchEscalationRule(rules, 5)
+	assert.True(t, ok)
+	assert.Equal(t, alert.P2, rule.priority)
+	rule, ok = matchEscalationRule(rules, 42)
+	assert.True(t, ok)
+	assert.Equal(t, alert.P1, rule.priority)
+}
+
+func TestPriorityRank(t *testing.T) {
+	assert.Equal(t, 1, priorityRank(alert.P1))
+	assert.Equal(t, 5, priorityRank(alert.P5))
+	assert.Equal(t, 6, priorityRank(""))
+	assert.True(t, priorityRank(alert.P2) < priorityRank(alert.P3))
+}
+
+func TestEscalatedPriority(t *testing.T) {
+	plugin.EscalationRules = "5=P2:sre-escalation,10=:ops"
+	defer func() { plugin.EscalationRules = "" }()
+	event := types.FixtureEvent("entity1", "check1")
+	event.Check.Occurrences = 4
+	assert.Equal(t, alert.P3, escalatedPriority(event, alert.P3))
+	event.Check.Occurrences = 5
+	assert.Equal(t, alert.P2, escalatedPriority(event, alert.P3))
+	assert.Equal(t, alert.P1, escalatedPriority(event, alert.P1))
+	// the last rule has no priority
+	event.Check.Occurrences = 10
+	assert.Equal(t, alert.P3, escalatedPriority(event, alert.P3))
+}
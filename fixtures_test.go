@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/betorvs/sensu-opsgenie-handler/mockserver"
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// readFixture reads an event from tests directory
+func readFixture(t *testing.T, name string) *types.Event {
+	eventJSON, err := ioutil.ReadFile(filepath.Join("tests", name))
+	assert.NoError(t, err)
+	event := &types.Event{}
+	assert.NoError(t, json.Unmarshal(eventJSON, event))
+	return event
+}
+
+// useMockServer points the handler to a local OpsGenie stand-in until the test ends
+func useMockServer(t *testing.T) *mockserver.Server {
+	server := mockserver.New()
+	authToken := plugin.AuthToken
+	plugin.APIURL = server.Host()
+	plugin.AuthToken = "mock-token"
+	plugin.AliasTemplate = "{{.Entity.Name}}/{{.Check.Name}}"
+	plugin.MessageTemplate = "{{.Entity.Name}}/{{.Check.Name}}"
+	plugin.DescriptionTemplate = "{{.Check.Output}}"
+	plugin.MessageLimit = 130
+	plugin.DescriptionLimit = 15000
+	plugin.Priority = "P3"
+	plugin.SensuDashboard = "disabled"
+	t.Cleanup(func() {
+		server.Close()
+		plugin.APIURL = ""
+		plugin.AuthToken = authToken
+	})
+	return server
+}
+
+func TestFixturesEndToEnd(t *testing.T) {
+	testCases := []struct {
+		fixture  string
+		alias    string
+		priority string
+	}{
+		{"event", "webserver01/check-nginx", "P5"},
+		{"event.withAnnotations", "webserver02/check-nginx", "P3"},
+		{"event_with_opsgenie_priority.check", "webserver03/check-nginx", "P1"},
+		{"event_with_opsgenie_priority", "webserver04/check-nginx", "P2"},
+		{"event_from_proxy", "k8s.example.com/nodes-ready-k8s-prod", "P2"},
+		{"event_new", "ssl-external-agent/sensu_ssl_certificates", "P3"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			server := useMockServer(t)
+			event := readFixture(t, tc.fixture+".json")
+			assert.NoError(t, checkArgs(event))
+			assert.NoError(t, executeHandler(event))
+			created, ok := server.Alert(tc.alias)
+			assert.True(t, ok)
+			assert.Equal(t, "open", created.Status)
+			assert.Equal(t, tc.priority, created.Priority)
+			assert.Equal(t, event.Check.Output, created.Description)
+
+			resolved := readFixture(t, tc.fixture+".resolved.json")
+			assert.NoError(t, executeHandler(resolved))
+			closed, _ := server.Alert(tc.alias)
+			assert.Equal(t, "closed", closed.Status)
+			assert.Equal(t, created.ID, closed.ID)
+		})
+	}
+}
+
+func TestResolvedFixtureWithoutAlert(t *testing.T) {
+	server := useMockServer(t)
+	event := readFixture(t, "event.resolved.json")
+	assert.NoError(t, executeHandler(event))
+	requests := server.Requests()
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "GET", requests[0].Method)
+}
+
+func TestHeartbeatEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	plugin.HeartbeatEvents = true
+	plugin.HeartbeatMap = "webserver01/all=heartbeat_webserver01"
+	defer func() {
+		plugin.HeartbeatEvents = false
+		plugin.HeartbeatMap = ""
+	}()
+	event := readFixture(t, "event.resolved.json")
+	assert.NoError(t, executeHandler(event))
+	assert.Equal(t, 1, server.Pings("heartbeat_webserver01"))
+}
+
+func TestRetriesEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	plugin.MaxRetries = 2
+	plugin.RetryBackoff = 1
+	defer func() {
+		plugin.MaxRetries = 0
+		plugin.RetryBackoff = 0
+	}()
+	event := readFixture(t, "event.json")
+
+	// two server errors are retried and the alert is created
+	server.FailNext(2, 503)
+	assert.NoError(t, executeHandler(event))
+	_, ok := server.Alert("webserver01/check-nginx")
+	assert.True(t, ok)
+	assert.Len(t, server.Requests(), 3)
+
+	// rate limits after max retries fail the handler
+	server.FailNext(3, 429)
+	assert.Error(t, executeHandler(event))
+
+	// authentication errors are not retried
+	server.FailNext(1, 401)
+	requests := len(server.Requests())
+	assert.Error(t, executeHandler(event))
+	assert.Len(t, server.Requests(), requests+1)
+}
+
+func TestResolvedFixtureWithLookupError(t *testing.T) {
+	server := useMockServer(t)
+	event := readFixture(t, "event.json")
+	assert.NoError(t, executeHandler(event))
+
+	// an auth error while looking for the alert fails the handler and leaves the alert open
+	server.FailNext(1, 401)
+	resolved := readFixture(t, "event.resolved.json")
+	err := executeHandler(resolved)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "get alert webserver01/check-nginx failed")
+	stillOpen, _ := server.Alert("webserver01/check-nginx")
+	assert.Equal(t, "open", stillOpen.Status)
+}
+
+func TestOutboxEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	useOutbox(t)
+	plugin.MaxRetries = 1
+	plugin.RetryBackoff = 1
+	defer func() {
+		plugin.MaxRetries = 0
+		plugin.RetryBackoff = 0
+	}()
+
+	// the create fails while OpsGenie is unreachable and is saved in outbox
+	server.FailNext(2, 503)
+	event := readFixture(t, "event.json")
+	err := executeHandler(event)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "request saved in outbox")
+	_, ok := server.Alert("webserver01/check-nginx")
+	assert.False(t, ok)
+
+	// flush subcommand replays it
+	status, err := executeFlush(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+	created, ok := server.Alert("webserver01/check-nginx")
+	assert.True(t, ok)
+	assert.Equal(t, "open", created.Status)
+
+	// the close fails too and is saved by alias, the next handler run replays it
+	server.FailNext(2, 503)
+	resolved := readFixture(t, "event.resolved.json")
+	assert.Error(t, executeHandler(resolved))
+	assert.Equal(t, []string{"close webserver01/check-nginx"}, outboxOperations(t))
+	assert.NoError(t, executeHandler(readFixture(t, "event_new.resolved.json")))
+	closed, _ := server.Alert("webserver01/check-nginx")
+	assert.Equal(t, "closed", closed.Status)
+	assert.Empty(t, outboxOperations(t))
+}
+
+func TestOutboxResolvedBeforeReplay(t *testing.T) {
+	server := useMockServer(t)
+	useOutbox(t)
+	plugin.MaxRetries = 1
+	plugin.RetryBackoff = 1
+	defer func() {
+		plugin.MaxRetries = 0
+		plugin.RetryBackoff = 0
+	}()
+
+	// the create is saved in outbox while OpsGenie is unreachable
+	server.FailNext(2, 503)
+	assert.Error(t, executeHandler(readFixture(t, "event.json")))
+	assert.Equal(t, []string{"create webserver01/check-nginx"}, outboxOperations(t))
+
+	// the check is OK before the next run, the stale create is dropped instead of opening the alert
+	assert.NoError(t, executeHandler(readFixture(t, "event.resolved.json")))
+	_, ok := server.Alert("webserver01/check-nginx")
+	assert.False(t, ok)
+	assert.Empty(t, outboxOperations(t))
+}
+
+func TestVerifyEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	plugin.Verify = true
+	verifyInterval = time.Millisecond
+	defer func() {
+		plugin.Verify = false
+		verifyInterval = 500 * time.Millisecond
+	}()
+
+	// create is confirmed after OpsGenie processes it
+	server.PendingStatus(2)
+	event := readFixture(t, "event.json")
+	assert.NoError(t, executeHandler(event))
+
+	// a request rejected after the 202 fails the handler with OpsGenie message
+	server.RejectNextCreate("Invalid responder: team missing does not exist")
+	err := executeHandler(readFixture(t, "event_new.json"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "team missing does not exist")
+
+	assert.NoError(t, executeHandler(readFixture(t, "event.resolved.json")))
+	closed, _ := server.Alert("webserver01/check-nginx")
+	assert.Equal(t, "closed", closed.Status)
+}
+
+func TestDeadlineEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	plugin.Timeout = "300ms"
+	plugin.CallTimeout = "100ms"
+	plugin.MaxRetries = 5
+	plugin.RetryBackoff = 1
+	defer func() {
+		plugin.Timeout = ""
+		plugin.CallTimeout = ""
+		plugin.MaxRetries = 0
+		plugin.RetryBackoff = 0
+	}()
+	server.Delay(time.Second)
+	start := time.Now()
+	err := executeHandler(readFixture(t, "event.resolved.json"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "handler timeout of 300ms reached before get alert webserver01/check-nginx finished")
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
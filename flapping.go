@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+)
+
+// --flapping-policy values and the tag added with tag policy
+const (
+	flappingHold = "hold"
+	flappingTag  = "tag"
+	flappingName = "flapping"
+)
+
+// checkFlapping func validates --flapping-policy, --flapping-priority and --flapping-stable-intervals
+func checkFlapping() error {
+	switch plugin.FlappingPolicy {
+	case "", flappingHold, flappingTag:
+	default:
+		return fmt.Errorf("invalid --flapping-policy %q: use hold or tag", plugin.FlappingPolicy)
+	}
+	if plugin.FlappingPolicy == flappingTag {
+		if _, err := parsePriority(plugin.FlappingPriority); err != nil {
+			return fmt.Errorf("invalid --flapping-priority: %s", err)
+		}
+	}
+	if plugin.FlappingStableIntervals < 0 {
+		return fmt.Errorf("--flapping-stable-intervals should be 0 or more")
+	}
+	return nil
+}
+
+// isFlapping func returns true if Sensu marked the check as flapping
+func isFlapping(event *types.Event) bool {
+	return event.Check != nil && event.Check.State == types.EventFlappingState
+}
+
+// stateChanges func returns how many times the check went from OK to not OK or back in its history
+func stateChanges(history []types.CheckHistory) int {
+	changes := 0
+	for i := 1; i < len(history); i++ {
+		if (history[i].Status == 0) != (history[i-1].Status == 0) {
+			changes++
+		}
+	}
+	return changes
+}
+
+// stableIntervals func returns how many of the last history entries are OK, the current execution is the last one
+func stableIntervals(history []types.CheckHistory) int {
+	stable := 0
+	for i := len(history) - 1; i >= 0 && history[i].Status == 0; i-- {
+		stable++
+	}
+	return stable
+}
+
+// flappingHeld func returns true if --flapping-policy hold delays the alert of a failing flapping check,
+// the alert is created when Sensu marks the check failing
+func flappingHeld(event *types.Event) bool {
+	return plugin.FlappingPolicy == flappingHold && event.Check.Status != 0 && isFlapping(event)
+}
+
+// flappingAlert func adds the flapping tag and lowers priority to --flapping-priority with --flapping-policy tag
+func flappingAlert(event *types.Event, priority alert.Priority, tags []string) (alert.Priority, []string) {
+	if plugin.FlappingPolicy != flappingTag || !isFlapping(event) {
+		return priority, tags
+	}
+	tags = appendUnique(tags, flappingName)
+	if flappingPriority, err := parsePriority(plugin.FlappingPriority); err == nil && priorityRank(flappingPriority) > priorityRank(priority) {
+		fmt.Printf("Flapping: lowering priority from %s to %s and adding tag %s, %d state changes in history \n", priority, flappingPriority, flappingName, stateChanges(event.Check.History))
+		return flappingPriority, tags
+	}
+	fmt.Printf("Flapping: adding tag %s, %d state changes in history \n", flappingName, stateChanges(event.Check.History))
+	return priority, tags
+}
+
+// closeHeld func returns true if the alert of a check Sensu still marks as flapping should stay open until
+// the last --flapping-stable-intervals history entries are OK. Handler filters like is_incident only pass the
+// first OK event, so with the default 0 the alert is closed on the first OK
+func closeHeld(event *types.Event, alias string) bool {
+	if plugin.FlappingPolicy == "" || plugin.FlappingStableIntervals == 0 || !isFlapping(event) {
+		return false
+	}
+	stable := stableIntervals(event.Check.History)
+	if stable < plugin.FlappingStableIntervals {
+		fmt.Printf("Flapping: not closing alert %s, check stable for %d of %d intervals \n", alias, stable, plugin.FlappingStableIntervals)
+		return true
+	}
+	fmt.Printf("Flapping: closing alert %s, check stable for %d intervals \n", alias, stable)
+	return false
+}
+
+// noteSnoozedHold func adds a note to a snoozed alert when its close is held, on the first OK only. OpsGenie API
+// cannot end a snooze, so the alert notifies again when the snooze ends if the check is still flapping
+func noteSnoozedHold(ctx context.Context, alertClient AlertAPI, event *types.Event, alias string) error {
+	if stableIntervals(event.Check.History) != 1 {
+		return nil
+	}
+	openAlert, err := findAlert(ctx, alertClient, alias)
+	if err != nil || openAlert == nil || openAlert.Status != "open" || !openAlert.Snoozed {
+		return err
+	}
+	note := fmt.Sprintf("Check resolved while flapping, the alert is kept open until the check is stable for %d intervals and stays snoozed until %s",
+		plugin.FlappingStableIntervals, openAlert.SnoozedUntil.UTC().Format(time.RFC3339))
+	return updateAlert(ctx, alertClient, note, openAlert.Id, nil)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// useFlapping sets --flapping-policy with P5 priority and 3 stable intervals until the test ends
+func useFlapping(t *testing.T, policy string) {
+	plugin.FlappingPolicy = policy
+	plugin.FlappingPriority = "P5"
+	plugin.FlappingStableIntervals = 3
+	t.Cleanup(func() {
+		plugin.FlappingPolicy = ""
+		plugin.FlappingPriority = ""
+		plugin.FlappingStableIntervals = 0
+	})
+}
+
+// flappingFixture returns an event of check1 with statuses as history, the last one is the event status
+func flappingFixture(state string, statuses ...uint32) *types.Event {
+	event := types.FixtureEvent("entity1", "check1")
+	event.Check.State = state
+	event.Check.History = []types.CheckHistory{}
+	for i, status := range statuses {
+		event.Check.History = append(event.Check.History, types.CheckHistory{Status: status, Executed: int64(1627984800 + i*60)})
+	}
+	event.Check.Status = statuses[len(statuses)-1]
+	return event
+}
+
+func TestCheckFlapping(t *testing.T) {
+	assert.NoError(t, checkFlapping())
+	useFlapping(t, "tag")
+	assert.NoError(t, checkFlapping())
+	plugin.FlappingPriority = "P9"
+	assert.Error(t, checkFlapping())
+	plugin.FlappingPolicy = "ignore"
+	assert.Error(t, checkFlapping())
+	plugin.FlappingPolicy = "hold"
+	plugin.FlappingStableIntervals = -1
+	assert.Error(t, checkFlapping())
+}
+
+func TestFlappingHistory(t *testing.T) {
+	event := flappingFixture("passing", 0, 2, 0, 0)
+	assert.Equal(t, 2, stateChanges(event.Check.History))
+	assert.Equal(t, 2, stableIntervals(event.Check.History))
+	event = flappingFixture("passing", 0, 2, 0, 1, 0)
+	assert.Equal(t, 4, stateChanges(event.Check.History))
+	assert.Equal(t, 1, stableIntervals(event.Check.History))
+	assert.Equal(t, 0, stableIntervals(flappingFixture("failing", 0, 2).Check.History))
+
+	// only the flapping state holds the close, not the history
+	useFlapping(t, "tag")
+	assert.False(t, closeHeld(event, "entity1/check1"))
+	assert.True(t, closeHeld(flappingFixture("flapping", 0, 2, 0, 1, 0), "entity1/check1"))
+	plugin.FlappingStableIntervals = 0
+	assert.False(t, closeHeld(flappingFixture("flapping", 0, 2, 0, 1, 0), "entity1/check1"))
+}
+
+func TestHandlerBranchFlapping(t *testing.T) {
+	event := flappingFixture("flapping", 0, 2, 0, 2)
+	assert.Equal(t, branchCreate, handlerBranch(event))
+	useFlapping(t, "hold")
+	assert.Equal(t, branchFlappingHold, handlerBranch(event))
+	assert.Equal(t, branchCreate, handlerBranch(flappingFixture("failing", 0, 2, 2)))
+	assert.Equal(t, branchClose, handlerBranch(flappingFixture("flapping", 0, 2, 0)))
+}
+
+func TestFlappingAlert(t *testing.T) {
+	event := flappingFixture("flapping", 0, 2, 0, 2)
+	priority, tags := flappingAlert(event, alert.P2, []string{"entity1"})
+	assert.Equal(t, alert.P2, priority)
+	assert.Equal(t, []string{"entity1"}, tags)
+
+	useFlapping(t, "tag")
+	priority, tags = flappingAlert(event, alert.P2, []string{"entity1"})
+	assert.Equal(t, alert.P5, priority)
+	assert.Equal(t, []string{"entity1", "flapping"}, tags)
+	plugin.FlappingPriority = "P4"
+	priority, _ = flappingAlert(event, alert.P5, nil)
+	assert.Equal(t, alert.P5, priority)
+	priority, tags = flappingAlert(flappingFixture("failing", 2, 2), alert.P2, nil)
+	assert.Equal(t, alert.P2, priority)
+	assert.Empty(t, tags)
+}
+
+func TestFlappingStatusChange(t *testing.T) {
+	useFlapping(t, "tag")
+	plugin.StatusPriorityMap = "1=P3,2=P1"
+	defer func() { plugin.StatusPriorityMap = "" }()
+	alertAPI := &fakeAlertAPI{}
+	openAlert := &alert.GetAlertResult{Id: "alert-id", Status: "open", Priority: alert.P5, Tags: []string{"entity1", "flapping"}, Details: map[string]string{"status": "1"}}
+
+	// warning to critical of a flapping check keeps the flapping priority and tag
+	assert.NoError(t, updateStatusChange(context.Background(), alertAPI, flappingFixture("flapping", 0, 1, 0, 2), openAlert, 1))
+	assert.Equal(t, alert.P5, openAlert.Priority)
+	for _, call := range alertAPI.calls {
+		assert.NotContains(t, call, "UpdatePriority")
+		assert.NotContains(t, call, "AddTags")
+	}
+
+	// the alert of a check that started flapping gets the tag and lower priority
+	openAlert = &alert.GetAlertResult{Id: "alert-id", Status: "open", Priority: alert.P3, Tags: []string{"entity1"}, Details: map[string]string{"status": "1"}}
+	assert.NoError(t, updateStatusChange(context.Background(), alertAPI, flappingFixture("flapping", 0, 1, 0, 2), openAlert, 1))
+	assert.Contains(t, alertAPI.calls, "UpdatePriority alert-id P5")
+	assert.Contains(t, alertAPI.calls, "AddTags alert-id flapping")
+	assert.Equal(t, []string{"entity1", "flapping"}, openAlert.Tags)
+}
+
+func TestFlappingEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	useFlapping(t, "tag")
+	plugin.FlappingStableIntervals = 0
+	assert.NoError(t, checkArgs(nil))
+	assert.NoError(t, executeHandler(flappingFixture("flapping", 0, 2, 0, 2)))
+	created, ok := server.Alert("entity1/check1")
+	assert.True(t, ok)
+	assert.Equal(t, "P5", created.Priority)
+	assert.Contains(t, created.Tags, "flapping")
+
+	// with is_incident only the first OK reaches the handler, by default it closes the alert
+	assert.NoError(t, executeHandler(flappingFixture("flapping", 0, 2, 0, 2, 0)))
+	closed, _ := server.Alert("entity1/check1")
+	assert.Equal(t, "closed", closed.Status)
+
+	// with --flapping-stable-intervals every OK reaches the handler, the alert stays open until the last 3 are OK
+	plugin.FlappingStableIntervals = 3
+	event := flappingFixture("flapping", 0, 2, 0, 2)
+	event.Entity.Name = "entity3"
+	assert.NoError(t, executeHandler(event))
+	for _, statuses := range [][]uint32{{0, 2, 0, 2, 0}, {0, 2, 0, 2, 0, 0}} {
+		event = flappingFixture("flapping", statuses...)
+		event.Entity.Name = "entity3"
+		assert.NoError(t, executeHandler(event))
+	}
+	open, _ := server.Alert("entity3/check1")
+	assert.Equal(t, "open", open.Status)
+	event = flappingFixture("flapping", 0, 2, 0, 2, 0, 0, 0)
+	event.Entity.Name = "entity3"
+	assert.NoError(t, executeHandler(event))
+	closed, _ = server.Alert("entity3/check1")
+	assert.Equal(t, "closed", closed.Status)
+
+	// hold policy does not create the alert while the check is flapping
+	plugin.FlappingPolicy = "hold"
+	event = flappingFixture("flapping", 0, 2, 0, 2)
+	event.Entity.Name = "entity2"
+	assert.NoError(t, executeHandler(event))
+	_, ok = server.Alert("entity2/check1")
+	assert.False(t, ok)
+	event = flappingFixture("failing", 0, 2, 0, 2, 2)
+	event.Entity.Name = "entity2"
+	assert.NoError(t, executeHandler(event))
+	held, ok := server.Alert("entity2/check1")
+	assert.True(t, ok)
+	assert.Equal(t, "P3", held.Priority)
+	assert.NotContains(t, held.Tags, "flapping")
+}
+
+func TestNoteSnoozedHold(t *testing.T) {
+	useFlapping(t, "tag")
+	snoozed := &alert.GetAlertResult{Id: "alert-id", Status: "open", Snoozed: true, SnoozedUntil: time.Date(2021, 8, 3, 18, 0, 0, 0, time.UTC)}
+	alertAPI := &fakeAlertAPI{alerts: map[string]*alert.GetAlertResult{"entity1/check1": snoozed}}
+
+	// the first held OK adds a note, the next ones do not look the alert up again
+	assert.NoError(t, noteSnoozedHold(context.Background(), alertAPI, flappingFixture("flapping", 0, 2, 0, 2, 0), "entity1/check1"))
+	assert.Equal(t, []string{"Get entity1/check1", "AddNote alert-id"}, alertAPI.calls)
+	assert.NoError(t, noteSnoozedHold(context.Background(), alertAPI, flappingFixture("flapping", 0, 2, 0, 2, 0, 0), "entity1/check1"))
+	assert.Len(t, alertAPI.calls, 2)
+
+	// an alert that is not snoozed gets no note
+	snoozed.Snoozed = false
+	alertAPI.calls = nil
+	assert.NoError(t, noteSnoozedHold(context.Background(), alertAPI, flappingFixture("flapping", 0, 2, 0, 2, 0), "entity1/check1"))
+	assert.Equal(t, []string{"Get entity1/check1"}, alertAPI.calls)
+}
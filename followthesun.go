@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+	"gopkg.in/yaml.v2"
+)
+
+// followTheSun represents the --follow-the-sun file, JSON files are read as YAML
+type followTheSun struct {
+	Shifts   []shift `yaml:"shifts"`
+	Fallback shift   `yaml:"fallback"`
+}
+
+// shift represents a UTC time window with its responders and visibility teams.
+// A window ending before it starts crosses midnight, like 22:00-06:00
+type shift struct {
+	Name       string   `yaml:"name"`
+	Start      string   `yaml:"start"`
+	End        string   `yaml:"end"`
+	Responders []string `yaml:"responders"`
+	Visibility []string `yaml:"visibility"`
+	start      int
+	end        int
+}
+
+// loadedShifts keeps --follow-the-sun read by activeShift, it is read once per handler run.
+// Targets of --targets resolve the shift concurrently, the mutex guards the cache
+var loadedShifts struct {
+	sync.Mutex
+	file string
+	sun  *followTheSun
+	err  error
+}
+
+// resetFollowTheSun func forgets --follow-the-sun read by a previous handler run
+func resetFollowTheSun() {
+	loadedShifts.Lock()
+	defer loadedShifts.Unlock()
+	loadedShifts.file, loadedShifts.sun, loadedShifts.err = "", nil, nil
+}
+
+// cachedFollowTheSun func returns --follow-the-sun read by this handler run, it reads the file
+// and warns about an invalid file the first time
+func cachedFollowTheSun() (*followTheSun, error) {
+	loadedShifts.Lock()
+	defer loadedShifts.Unlock()
+	if (loadedShifts.sun != nil || loadedShifts.err != nil) && loadedShifts.file == plugin.FollowTheSun {
+		return loadedShifts.sun, loadedShifts.err
+	}
+	sun, err := loadFollowTheSun()
+	if err != nil {
+		fmt.Printf("[WARN] Ignoring follow-the-sun: %s \n", err)
+	}
+	loadedShifts.file, loadedShifts.sun, loadedShifts.err = plugin.FollowTheSun, sun, err
+	return sun, err
+}
+
+// loadFollowTheSun func reads and validates --follow-the-sun
+func loadFollowTheSun() (*followTheSun, error) {
+	content, err := ioutil.ReadFile(plugin.FollowTheSun)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read --follow-the-sun: %s", err)
+	}
+	return parseFollowTheSun(content)
+}
+
+// parseFollowTheSun func parses a YAML or JSON follow-the-sun file and validates it
+func parseFollowTheSun(content []byte) (*followTheSun, error) {
+	sun := &followTheSun{}
+	if err := yaml.UnmarshalStrict(content, sun); err != nil {
+		return nil, fmt.Errorf("invalid follow-the-sun file: %s", err)
+	}
+	if len(sun.Shifts) == 0 {
+		return nil, fmt.Errorf("follow-the-sun file has no shifts")
+	}
+	for i := range sun.Shifts {
+		s := &sun.Shifts[i]
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("shift-%d", i+1)
+		}
+		var err error
+		if s.start, err = parseClock(s.Start); err != nil {
+			return nil, fmt.Errorf("follow-the-sun shift %s %s", s.Name, err)
+		}
+		if s.end, err = parseClock(s.End); err != nil {
+			return nil, fmt.Errorf("follow-the-sun shift %s %s", s.Name, err)
+		}
+		if s.start == s.end {
+			return nil, fmt.Errorf("follow-the-sun shift %s should start before it ends", s.Name)
+		}
+		if len(s.Responders) == 0 {
+			return nil, fmt.Errorf("follow-the-sun shift %s has no responders", s.Name)
+		}
+		if err := s.check(); err != nil {
+			return nil, err
+		}
+	}
+	if sun.Fallback.Name == "" {
+		sun.Fallback.Name = "fallback"
+	}
+	if err := sun.Fallback.check(); err != nil {
+		return nil, err
+	}
+	return sun, nil
+}
+
+// check func validates responders and visibility of a shift
+func (s shift) check() error {
+	for _, v := range s.Responders {
+		if _, err := parseResponder(v); err != nil {
+			return fmt.Errorf("follow-the-sun shift %s invalid responder: %s", s.Name, err)
+		}
+	}
+	if _, err := parseVisibility(s.Visibility); err != nil {
+		return fmt.Errorf("follow-the-sun shift %s invalid visibility: %s", s.Name, err)
+	}
+	return nil
+}
+
+// active func returns true if minutes since UTC midnight are inside the shift
+func (s shift) active(minutes int) bool {
+	if s.start < s.end {
+		return minutes >= s.start && minutes < s.end
+	}
+	return minutes >= s.start || minutes < s.end
+}
+
+// activeShift func returns the first shift active at the event time, or the fallback shift if it has responders.
+// It returns nil without --follow-the-sun or when no shift applies, then the flags are used
+func activeShift(event *types.Event) *shift {
+	if plugin.FollowTheSun == "" {
+		return nil
+	}
+	sun, err := cachedFollowTheSun()
+	if err != nil {
+		return nil
+	}
+	utc := eventTime(event).UTC()
+	minutes := utc.Hour()*60 + utc.Minute()
+	for _, s := range sun.Shifts {
+		if s.active(minutes) {
+			return &s
+		}
+	}
+	if len(sun.Fallback.Responders) != 0 || len(sun.Fallback.Visibility) != 0 {
+		return &sun.Fallback
+	}
+	return nil
+}
+
+// shiftResponders func returns the shift responders and --responder, the flags without a shift with responders
+func shiftResponders(s *shift) []alert.Responder {
+	if s == nil || len(s.Responders) == 0 {
+		return respondersTeam()
+	}
+	fmt.Printf("Follow-the-sun shift %s: responders %s \n", s.Name, strings.Join(s.Responders, ","))
+	return append(s.responders(), flagResponders()...)
+}
+
+// shiftVisibility func returns the shift visibility, --visibility-teams without a shift with visibility
+func shiftVisibility(s *shift) []alert.Responder {
+	if s == nil || len(s.Visibility) == 0 {
+		return visibilityTeams()
+	}
+	return s.visibleTo()
+}
+
+// responders func returns the responders of the shift
+func (s *shift) responders() []alert.Responder {
+	local := []alert.Responder{}
+	for _, v := range s.Responders {
+		if responder, err := parseResponder(v); err == nil {
+			local = append(local, responder)
+		}
+	}
+	return local
+}
+
+// visibleTo func returns the visibility of the shift
+func (s *shift) visibleTo() []alert.Responder {
+	visibility, _ := parseVisibility(s.Visibility)
+	return visibility
+}
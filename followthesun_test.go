@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// useFollowTheSun sets --follow-the-sun to tests/followthesun.yaml and now to hour:minute UTC until the test ends
+func useFollowTheSun(t *testing.T, hour, minute int) {
+	plugin.FollowTheSun = filepath.Join("tests", "followthesun.yaml")
+	now = func() time.Time { return shiftTime(hour, minute) }
+	t.Cleanup(func() {
+		plugin.FollowTheSun = ""
+		now = time.Now
+		resetFollowTheSun()
+	})
+}
+
+// shiftTime returns hour:minute UTC of the day used by follow-the-sun tests
+func shiftTime(hour, minute int) time.Time {
+	return time.Date(2021, 8, 3, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParseFollowTheSun(t *testing.T) {
+	sun, err := parseFollowTheSun([]byte("shifts:\n  - {start: \"08:00\", end: \"16:00\", responders: [\"team:emea\"]}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "shift-1", sun.Shifts[0].Name)
+	assert.Equal(t, 8*60, sun.Shifts[0].start)
+
+	invalid := []string{
+		"shifts: []",
+		"shifts:\n  - {start: \"8\", end: \"16:00\", responders: [\"team:emea\"]}",
+		"shifts:\n  - {start: \"08:00\", end: \"08:00\", responders: [\"team:emea\"]}",
+		"shifts:\n  - {start: \"08:00\", end: \"16:00\"}",
+		"shifts:\n  - {start: \"08:00\", end: \"16:00\", responders: [emea]}",
+		"shifts:\n  - {start: \"08:00\", end: \"16:00\", responders: [\"team:emea\"], visibility: [\"schedule:emea\"]}",
+		"shifts:\n  - {start: \"08:00\", end: \"16:00\", responders: [\"team:emea\"]}\nfallback: {responders: [sre]}",
+		"shifts:\n  - {from: \"08:00\"}",
+	}
+	for _, v := range invalid {
+		_, err := parseFollowTheSun([]byte(v))
+		assert.Error(t, err, v)
+	}
+}
+
+func TestActiveShift(t *testing.T) {
+	testCases := []struct {
+		hour     int
+		minute   int
+		expected string
+	}{
+		{2, 0, "apac"},
+		{23, 30, "apac"},
+		{6, 0, "emea"},
+		{13, 59, "emea"},
+		{14, 30, "fallback"},
+		{21, 59, "amer"},
+	}
+	for _, tc := range testCases {
+		// the event time is used, now() only for events without timestamp
+		useFollowTheSun(t, 12, 0)
+		event := types.FixtureEvent("entity1", "check1")
+		event.Timestamp = shiftTime(tc.hour, tc.minute).Unix()
+		shift := activeShift(event)
+		assert.NotNil(t, shift)
+		assert.Equal(t, tc.expected, shift.Name, "%02d:%02d", tc.hour, tc.minute)
+		useFollowTheSun(t, tc.hour, tc.minute)
+		event.Timestamp = 0
+		assert.Equal(t, tc.expected, activeShift(event).Name, "%02d:%02d", tc.hour, tc.minute)
+	}
+
+	plugin.FollowTheSun = ""
+	assert.Nil(t, activeShift(nil))
+}
+
+func TestActiveShiftReadOnce(t *testing.T) {
+	content, err := ioutil.ReadFile(filepath.Join("tests", "followthesun.yaml"))
+	assert.NoError(t, err)
+	useFollowTheSun(t, 2, 0)
+	plugin.FollowTheSun = filepath.Join(t.TempDir(), "followthesun.yaml")
+	assert.NoError(t, ioutil.WriteFile(plugin.FollowTheSun, content, 0600))
+	assert.Equal(t, "apac", activeShift(nil).Name)
+
+	// the file is not read again during the handler run
+	assert.NoError(t, os.Remove(plugin.FollowTheSun))
+	assert.Equal(t, "apac", activeShift(nil).Name)
+
+	// the next handler run reads it again, a missing file is ignored
+	resetFollowTheSun()
+	assert.Nil(t, activeShift(nil))
+}
+
+func TestFollowTheSunResponders(t *testing.T) {
+	plugin.Team = "sre-default"
+	plugin.VisibilityTeams = "managers"
+	defer func() {
+		plugin.Team = ""
+		plugin.VisibilityTeams = ""
+	}()
+	useFollowTheSun(t, 2, 0)
+	shift := activeShift(nil)
+	assert.Equal(t, []alert.Responder{
+		{Type: alert.TeamResponder, Name: "apac-sre"},
+		{Type: alert.ScheduleResponder, Name: "apac-oncall"},
+	}, shiftResponders(shift))
+	assert.Equal(t, []alert.Responder{{Type: alert.TeamResponder, Name: "apac-managers"}}, shiftVisibility(shift))
+
+	// the fallback shift has no visibility, --visibility-teams is used
+	useFollowTheSun(t, 14, 30)
+	shift = activeShift(nil)
+	assert.Equal(t, []alert.Responder{{Type: alert.TeamResponder, Name: "sre"}}, shiftResponders(shift))
+	assert.Equal(t, []alert.Responder{{Type: alert.TeamResponder, Name: "managers"}}, shiftVisibility(shift))
+
+	// without a shift the flags are used
+	assert.Equal(t, respondersTeam(), shiftResponders(nil))
+	assert.Equal(t, visibilityTeams(), shiftVisibility(nil))
+
+	// rules teams win over the shift
+	r := &routing{teams: []string{"web"}}
+	assert.Equal(t, []alert.Responder{{Type: alert.TeamResponder, Name: "web"}}, r.responders(shift))
+}
+
+func TestFollowTheSunEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	plugin.Team = "sre-default"
+	defer func() { plugin.Team = "" }()
+	// the handler runs at 14:30 UTC for an event of 02:00 UTC, the apac shift of the event time is used
+	useFollowTheSun(t, 14, 30)
+	event := types.FixtureEvent("entity1", "check1")
+	event.Timestamp = shiftTime(2, 0).Unix()
+	event.Check.Status = 2
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, executeHandler(event))
+	created, ok := server.Alert("entity1/check1")
+	assert.True(t, ok)
+	assert.Len(t, created.Responders, 2)
+	assert.Equal(t, "apac-sre", created.Responders[0].Name)
+	assert.Equal(t, "apac-managers", created.VisibleTo[0].Name)
+
+	plugin.FollowTheSun = filepath.Join("tests", "missing.yaml")
+	assert.Error(t, checkArgs(event))
+}
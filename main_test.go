@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
 	"github.com/opsgenie/opsgenie-go-sdk-v2/client"
+	"github.com/opsgenie/opsgenie-go-sdk-v2/heartbeat"
 	"github.com/sensu/sensu-go/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -21,10 +26,89 @@ func TestGetNote(t *testing.T) {
 }
 
 func TestEventPriority(t *testing.T) {
+	plugin.Keyspace = "sensu.io/plugins/sensu-opsgenie-handler/config"
 	plugin.Priority = "P1"
-	priority := eventPriority()
+	event := types.FixtureEvent("foo", "bar")
+	priority := eventPriority(event)
 	expectedValue := alert.P1
 	assert.Contains(t, priority, expectedValue)
+
+	// entity annotation is used when check has no priority
+	event.Entity.Annotations = map[string]string{"opsgenie_priority": "P4"}
+	assert.Equal(t, alert.P4, eventPriority(event))
+
+	// check annotation overrides entity annotation
+	event.Check.Annotations = map[string]string{"sensu.io/plugins/sensu-opsgenie-handler/config/priority": "p2"}
+	assert.Equal(t, alert.P2, eventPriority(event))
+
+	// invalid check annotation falls back to entity annotation
+	event.Check.Annotations = map[string]string{"opsgenie_priority": "urgent"}
+	assert.Equal(t, alert.P4, eventPriority(event))
+
+	// invalid priority everywhere falls back to P3
+	event.Entity.Annotations = nil
+	plugin.Priority = "P9"
+	assert.Equal(t, alert.P3, eventPriority(event))
+	plugin.Priority = "P3"
+}
+
+func TestStatusPriority(t *testing.T) {
+	plugin.StatusPriorityMap = "0=P5,1=P3,2=P1,3+=P4"
+	defer func() { plugin.StatusPriorityMap = "" }()
+	testCases := []struct {
+		status   uint32
+		expected alert.Priority
+	}{
+		{0, alert.P5},
+		{1, alert.P3},
+		{2, alert.P1},
+		{3, alert.P4},
+		{127, alert.P4},
+	}
+	for _, tc := range testCases {
+		priority, ok := statusPriority(tc.status)
+		assert.True(t, ok)
+		assert.Equal(t, tc.expected, priority)
+	}
+	plugin.StatusPriorityMap = "1=P3"
+	_, ok := statusPriority(2)
+	assert.False(t, ok)
+
+	// annotation wins over status map
+	plugin.StatusPriorityMap = "2=P1"
+	event := types.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	assert.Equal(t, alert.P1, eventPriority(event))
+	event.Check.Annotations = map[string]string{"opsgenie_priority": "P4"}
+	assert.Equal(t, alert.P4, eventPriority(event))
+}
+
+func TestParseStatusPriorityMap(t *testing.T) {
+	rules, err := parseStatusPriorityMap("0=P5,2+=p1,")
+	assert.NoError(t, err)
+	assert.Equal(t, []statusPriorityRule{
+		{status: 0, priority: alert.P5},
+		{status: 2, orHigher: true, priority: alert.P1},
+	}, rules)
+	rules, err = parseStatusPriorityMap("")
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+	_, err = parseStatusPriorityMap("warning=P3")
+	assert.Error(t, err)
+	_, err = parseStatusPriorityMap("1=P7")
+	assert.Error(t, err)
+	_, err = parseStatusPriorityMap("1")
+	assert.Error(t, err)
+}
+
+func TestParsePriority(t *testing.T) {
+	priority, err := parsePriority(" p5 ")
+	assert.NoError(t, err)
+	assert.Equal(t, alert.P5, priority)
+	_, err = parsePriority("P0")
+	assert.Error(t, err)
+	_, err = parsePriority("")
+	assert.Error(t, err)
 }
 
 func TestParseActions(t *testing.T) {
@@ -242,3 +326,475 @@ func TestSplitStringInSlice(t *testing.T) {
 	assert.Equal(t, expected4, res4)
 	assert.Equal(t, expected5, len(res4))
 }
+
+func TestStatusChanged(t *testing.T) {
+	event := types.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	openAlert := &alert.GetAlertResult{Details: map[string]string{"status": "1"}}
+	previous, changed := statusChanged(openAlert, event)
+	assert.True(t, changed)
+	assert.Equal(t, uint32(1), previous)
+
+	openAlert.Details["status"] = "2"
+	_, changed = statusChanged(openAlert, event)
+	assert.False(t, changed)
+
+	// without status in details use check history
+	openAlert.Details = map[string]string{}
+	event.Check.History = []types.CheckHistory{{Status: 2}, {Status: 1}}
+	event.Check.Status = 1
+	previous, changed = statusChanged(openAlert, event)
+	assert.True(t, changed)
+	assert.Equal(t, uint32(2), previous)
+
+	event.Check.History = nil
+	_, changed = statusChanged(openAlert, event)
+	assert.False(t, changed)
+}
+
+// fakeAlertAPI implements AlertAPI keeping alerts in memory by alias and recording every call
+// failures are returned, one per call, before any call succeeds and getErr is returned by every Get.
+// requestStatus is returned by GetRequestStatus, a successful status is returned if it is nil
+type fakeAlertAPI struct {
+	alerts        map[string]*alert.GetAlertResult
+	calls         []string
+	failures      []error
+	getErr        error
+	requestStatus *alert.RequestStatusResult
+}
+
+func (f *fakeAlertAPI) result(call string) (*alert.AsyncAlertResult, error) {
+	f.calls = append(f.calls, call)
+	if len(f.failures) != 0 {
+		err := f.failures[0]
+		f.failures = f.failures[1:]
+		return nil, err
+	}
+	return &alert.AsyncAlertResult{ResultMetadata: client.ResultMetadata{RequestId: "request-id"}}, nil
+}
+
+func (f *fakeAlertAPI) Create(ctx context.Context, req *alert.CreateAlertRequest) (*alert.AsyncAlertResult, error) {
+	return f.result("Create " + req.Alias + " " + string(req.Priority))
+}
+
+func (f *fakeAlertAPI) Get(ctx context.Context, req *alert.GetAlertRequest) (*alert.GetAlertResult, error) {
+	f.calls = append(f.calls, "Get "+req.IdentifierValue)
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	if found, ok := f.alerts[req.IdentifierValue]; ok {
+		// every response is a new value, like the OpsGenie API
+		copied := *found
+		return &copied, nil
+	}
+	return nil, &client.ApiError{StatusCode: 404, Message: "Alert does not exist"}
+}
+
+func (f *fakeAlertAPI) Close(ctx context.Context, req *alert.CloseAlertRequest) (*alert.AsyncAlertResult, error) {
+	return f.result("Close " + req.IdentifierValue)
+}
+
+func (f *fakeAlertAPI) AddNote(ctx context.Context, req *alert.AddNoteRequest) (*alert.AsyncAlertResult, error) {
+	return f.result("AddNote " + req.IdentifierValue)
+}
+
+func (f *fakeAlertAPI) Acknowledge(ctx context.Context, req *alert.AcknowledgeAlertRequest) (*alert.AsyncAlertResult, error) {
+	return f.result("Acknowledge " + req.IdentifierValue)
+}
+
+func (f *fakeAlertAPI) Snooze(ctx context.Context, req *alert.SnoozeAlertRequest) (*alert.AsyncAlertResult, error) {
+	return f.result("Snooze " + req.IdentifierValue)
+}
+
+func (f *fakeAlertAPI) AddDetails(ctx context.Context, req *alert.AddDetailsRequest) (*alert.AsyncAlertResult, error) {
+	return f.result("AddDetails " + req.IdentifierValue)
+}
+
+func (f *fakeAlertAPI) AddResponder(ctx context.Context, req *alert.AddResponderRequest) (*alert.AsyncAlertResult, error) {
+	return f.result("AddResponder " + req.IdentifierValue + " " + req.Responder.Name)
+}
+
+func (f *fakeAlertAPI) AddTags(ctx context.Context, req *alert.AddTagsRequest) (*alert.AsyncAlertResult, error) {
+	return f.result("AddTags " + req.IdentifierValue + " " + strings.Join(req.Tags, ","))
+}
+
+func (f *fakeAlertAPI) UpdatePriority(ctx context.Context, req *alert.UpdatePriorityRequest) (*alert.AsyncAlertResult, error) {
+	return f.result("UpdatePriority " + req.IdentifierValue + " " + string(req.Priority))
+}
+
+func (f *fakeAlertAPI) UpdateMessage(ctx context.Context, req *alert.UpdateMessageRequest) (*alert.AsyncAlertResult, error) {
+	return f.result("UpdateMessage " + req.IdentifierValue)
+}
+
+func (f *fakeAlertAPI) UpdateDescription(ctx context.Context, req *alert.UpdateDescriptionRequest) (*alert.AsyncAlertResult, error) {
+	return f.result("UpdateDescription " + req.IdentifierValue)
+}
+
+func (f *fakeAlertAPI) GetRequestStatus(ctx context.Context, req *alert.GetRequestStatusRequest) (*alert.RequestStatusResult, error) {
+	f.calls = append(f.calls, "GetRequestStatus "+req.RequestId)
+	if f.requestStatus != nil {
+		return f.requestStatus, nil
+	}
+	return &alert.RequestStatusResult{IsSuccess: true, Status: "Created alert", AlertID: "alert-id"}, nil
+}
+
+// fakeHeartbeatAPI implements HeartbeatAPI recording every ping
+type fakeHeartbeatAPI struct {
+	pings []string
+}
+
+func (f *fakeHeartbeatAPI) Ping(ctx context.Context, heartbeatName string) (*heartbeat.PingResult, error) {
+	f.pings = append(f.pings, heartbeatName)
+	return &heartbeat.PingResult{ResultMetadata: client.ResultMetadata{RequestId: "request-id"}, Message: "PONG - Heartbeat received"}, nil
+}
+
+// useFakeClients replaces the OpsGenie clients used by executeHandler until the test ends
+func useFakeClients(t *testing.T, alerts map[string]*alert.GetAlertResult) (*fakeAlertAPI, *fakeHeartbeatAPI) {
+	alertAPI := &fakeAlertAPI{alerts: alerts}
+	heartbeatAPI := &fakeHeartbeatAPI{}
+	originalAlertClient, originalHeartbeatClient := newAlertClient, newHeartbeatClient
+	newAlertClient = func(config *client.Config) (AlertAPI, error) { return alertAPI, nil }
+	newHeartbeatClient = func(config *client.Config) (HeartbeatAPI, error) { return heartbeatAPI, nil }
+	t.Cleanup(func() {
+		newAlertClient, newHeartbeatClient = originalAlertClient, originalHeartbeatClient
+	})
+	return alertAPI, heartbeatAPI
+}
+
+func TestExecuteHandler(t *testing.T) {
+	openAlert := &alert.GetAlertResult{Id: "alert-id", Status: "open", Priority: alert.P3, Details: map[string]string{"status": "1"}}
+	testCases := []struct {
+		name            string
+		status          uint32
+		remediation     bool
+		heartbeat       bool
+		alerts          map[string]*alert.GetAlertResult
+		failures        []error
+		getErr          error
+		expectedError   bool
+		expectedCalls   []string
+		expectedPings   []string
+		configurePlugin func()
+		configureEvent  func(event *types.Event)
+	}{
+		{
+			name:          "create",
+			status:        2,
+			expectedCalls: []string{"Create entity1/check1 P3"},
+		},
+		{
+			name:          "close",
+			status:        0,
+			alerts:        map[string]*alert.GetAlertResult{"entity1/check1": openAlert},
+			expectedCalls: []string{"Get entity1/check1", "Close alert-id"},
+		},
+		{
+			name:          "close without alert",
+			status:        0,
+			expectedCalls: []string{"Get entity1/check1"},
+		},
+		{
+			name:          "remediation update",
+			status:        0,
+			remediation:   true,
+			alerts:        map[string]*alert.GetAlertResult{"entity1/original": openAlert},
+			expectedCalls: []string{"Get entity1/original", "AddNote alert-id"},
+		},
+		{
+			name:          "remediation drop",
+			status:        1,
+			remediation:   true,
+			expectedCalls: nil,
+		},
+		{
+			name:          "heartbeat ping",
+			status:        0,
+			heartbeat:     true,
+			expectedPings: []string{"heartbeat1"},
+		},
+		{
+			name:      "heartbeat drop",
+			status:    2,
+			heartbeat: true,
+		},
+		{
+			name:          "status change update",
+			status:        2,
+			alerts:        map[string]*alert.GetAlertResult{"entity1/check1": openAlert},
+			expectedCalls: []string{"Get entity1/check1", "UpdatePriority alert-id P1", "UpdateMessage alert-id", "UpdateDescription alert-id", "AddDetails alert-id"},
+			configurePlugin: func() {
+				plugin.UpdateOnStatusChange = true
+				plugin.StatusPriorityMap = "2=P1"
+			},
+		},
+		{
+			name:          "escalation",
+			status:        2,
+			alerts:        map[string]*alert.GetAlertResult{"entity1/check1": openAlert},
+			expectedCalls: []string{"Get entity1/check1", "Create entity1/check1 P3", "UpdatePriority alert-id P2", "AddResponder alert-id sre", "AddDetails alert-id"},
+			configurePlugin: func() {
+				plugin.EscalationRules = "1=P2:sre"
+			},
+		},
+		{
+			name:          "escalation after status change update reuses the alert",
+			status:        2,
+			alerts:        map[string]*alert.GetAlertResult{"entity1/check1": {Id: "alert-id", Status: "open", Priority: alert.P3, Details: map[string]string{"status": "1"}}},
+			expectedCalls: []string{"Get entity1/check1", "UpdatePriority alert-id P1", "UpdateMessage alert-id", "UpdateDescription alert-id", "AddDetails alert-id", "AddResponder alert-id sre", "AddDetails alert-id"},
+			configurePlugin: func() {
+				plugin.UpdateOnStatusChange = true
+				plugin.StatusPriorityMap = "2=P1"
+				plugin.EscalationRules = "1=P2:sre"
+			},
+		},
+		{
+			name:          "status change update keeps the escalated priority",
+			status:        2,
+			alerts:        map[string]*alert.GetAlertResult{"entity1/check1": {Id: "alert-id", Status: "open", Priority: alert.P2, Details: map[string]string{"status": "1", escalationDetail: "5"}}},
+			expectedCalls: []string{"Get entity1/check1", "UpdateMessage alert-id", "UpdateDescription alert-id", "AddDetails alert-id"},
+			configurePlugin: func() {
+				plugin.UpdateOnStatusChange = true
+				plugin.EscalationRules = "5=P2:sre"
+			},
+			configureEvent: func(event *types.Event) {
+				event.Check.Occurrences = 5
+			},
+		},
+		{
+			name:          "flapping hold without alert is not created when silenced",
+			status:        2,
+			expectedCalls: []string{"Get entity1/check1"},
+			configurePlugin: func() {
+				plugin.FlappingPolicy = flappingHold
+				plugin.AcknowledgeSilenced = true
+				plugin.SnoozeSilenced = true
+			},
+			configureEvent: func(event *types.Event) {
+				event.Check.State = types.EventFlappingState
+				event.Check.IsSilenced = true
+			},
+		},
+		{
+			name:          "create retried after server error",
+			status:        2,
+			failures:      []error{&client.ApiError{StatusCode: 503}},
+			expectedCalls: []string{"Create entity1/check1 P3", "Create entity1/check1 P3"},
+		},
+		{
+			name:          "create fails with permanent error",
+			status:        2,
+			failures:      []error{&client.ApiError{StatusCode: 422}},
+			expectedError: true,
+			expectedCalls: []string{"Create entity1/check1 P3"},
+		},
+		{
+			name:          "close fails after retries",
+			status:        0,
+			alerts:        map[string]*alert.GetAlertResult{"entity1/check1": openAlert},
+			failures:      []error{&client.ApiError{StatusCode: 500}, &client.ApiError{StatusCode: 500}, &client.ApiError{StatusCode: 500}},
+			expectedError: true,
+			expectedCalls: []string{"Get entity1/check1", "Close alert-id", "Close alert-id", "Close alert-id"},
+		},
+		{
+			name:          "remediation update fails",
+			status:        0,
+			remediation:   true,
+			alerts:        map[string]*alert.GetAlertResult{"entity1/original": openAlert},
+			failures:      []error{&client.ApiError{StatusCode: 403}},
+			expectedError: true,
+			expectedCalls: []string{"Get entity1/original", "AddNote alert-id"},
+		},
+		{
+			name:          "create verified",
+			status:        2,
+			expectedCalls: []string{"Create entity1/check1 P3", "GetRequestStatus request-id"},
+			configurePlugin: func() {
+				plugin.Verify = true
+			},
+		},
+		{
+			name:          "remediation update without alert",
+			status:        0,
+			remediation:   true,
+			expectedCalls: []string{"Get entity1/original"},
+		},
+		{
+			name:          "close fails when lookup fails",
+			status:        0,
+			alerts:        map[string]*alert.GetAlertResult{"entity1/check1": openAlert},
+			getErr:        &client.ApiError{StatusCode: 401, Message: "Could not authenticate"},
+			expectedError: true,
+			expectedCalls: []string{"Get entity1/check1"},
+		},
+		{
+			name:          "status change fails when lookup fails",
+			status:        2,
+			getErr:        &client.ApiError{StatusCode: 403},
+			expectedError: true,
+			expectedCalls: []string{"Get entity1/check1"},
+			configurePlugin: func() {
+				plugin.UpdateOnStatusChange = true
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			plugin.RemediationEvents = tc.remediation
+			plugin.RemediationEventAlias = "entity1/original"
+			plugin.HeartbeatEvents = tc.heartbeat
+			plugin.HeartbeatMap = "entity1/check1=heartbeat1"
+			plugin.AliasTemplate = "{{.Entity.Name}}/{{.Check.Name}}"
+			plugin.MessageTemplate = "{{.Entity.Name}}/{{.Check.Name}}"
+			plugin.Priority = "P3"
+			plugin.SensuDashboard = "disabled"
+			plugin.MaxRetries = 2
+			plugin.RetryBackoff = 1
+			defer func() {
+				plugin.MaxRetries = 0
+				plugin.RemediationEvents = false
+				plugin.HeartbeatEvents = false
+				plugin.UpdateOnStatusChange = false
+				plugin.StatusPriorityMap = ""
+				plugin.EscalationRules = ""
+				plugin.Verify = false
+				plugin.FlappingPolicy = ""
+				plugin.AcknowledgeSilenced = false
+				plugin.SnoozeSilenced = false
+			}()
+			if tc.configurePlugin != nil {
+				tc.configurePlugin()
+			}
+			alertAPI, heartbeatAPI := useFakeClients(t, tc.alerts)
+			alertAPI.failures = tc.failures
+			alertAPI.getErr = tc.getErr
+			event := types.FixtureEvent("entity1", "check1")
+			event.Check.Status = tc.status
+			event.Check.Output = "new output"
+			event.Check.Occurrences = 1
+			if tc.configureEvent != nil {
+				tc.configureEvent(event)
+			}
+			err := executeHandler(event)
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedCalls, alertAPI.calls)
+			assert.Equal(t, tc.expectedPings, heartbeatAPI.pings)
+		})
+	}
+}
+
+func TestGetAlert(t *testing.T) {
+	testCases := []struct {
+		name          string
+		code          int
+		body          string
+		expected      string
+		expectedError bool
+		requests      int
+	}{
+		{
+			name:     "found",
+			code:     http.StatusOK,
+			body:     `{"data":{"id":"alert-id","alias":"entity1/check1","status":"open"},"took":0.1,"requestId":"request-id"}`,
+			expected: "alert-id",
+			requests: 1,
+		},
+		{
+			name:     "not found",
+			code:     http.StatusNotFound,
+			body:     `{"message":"Alert does not exist","took":0.1,"requestId":"request-id"}`,
+			expected: notFound,
+			requests: 1,
+		},
+		{
+			name:          "unauthorized",
+			code:          http.StatusUnauthorized,
+			body:          `{"message":"Could not authenticate","took":0.1,"requestId":"request-id"}`,
+			expectedError: true,
+			requests:      1,
+		},
+		{
+			name:          "rate limited",
+			code:          http.StatusTooManyRequests,
+			body:          `{"message":"Too many requests","took":0.1,"requestId":"request-id"}`,
+			expectedError: true,
+			requests:      2,
+		},
+		{
+			name:          "server error",
+			code:          http.StatusInternalServerError,
+			body:          `{"message":"Internal error","took":0.1,"requestId":"request-id"}`,
+			expectedError: true,
+			requests:      2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				assert.Equal(t, "/v2/alerts/entity1/check1", r.URL.Path)
+				assert.Equal(t, "alias", r.URL.Query().Get("identifierType"))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.code)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+			plugin.APIURL = strings.TrimPrefix(server.URL, "http://")
+			plugin.AuthToken = "stub-token"
+			plugin.MaxRetries = 1
+			plugin.RetryBackoff = 1
+			defer func() {
+				plugin.APIURL = ""
+				plugin.AuthToken = ""
+				plugin.MaxRetries = 0
+				plugin.RetryBackoff = 0
+			}()
+			config, err := opsgenieConfig(context.Background())
+			assert.NoError(t, err)
+			alertClient, err := newAlertClient(config)
+			assert.NoError(t, err)
+			id, err := getAlert(context.Background(), alertClient, "entity1/check1")
+			if tc.expectedError {
+				assert.Error(t, err)
+				assert.False(t, isNotFound(err))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, id)
+			}
+			assert.Equal(t, tc.requests, requests)
+		})
+	}
+}
+
+func TestAnnotationOverridesDisabled(t *testing.T) {
+	disabled := map[string]bool{
+		"api-url":                 true,
+		"backend":                 true,
+		"proxy-url":               true,
+		"ca-bundle":               true,
+		"client-cert":             true,
+		"client-key":              true,
+		"tenant-map":              true,
+		"tenant-label":            true,
+		"targets":                 true,
+		"sensu-api-url":           true,
+		"sensu-api-key":           true,
+		"outbox-dir":              true,
+		"rules-file":              true,
+		"follow-the-sun":          true,
+		"business-hours-holidays": true,
+		"silences-file":           true,
+	}
+	found := 0
+	for _, option := range options {
+		if disabled[option.Argument] {
+			assert.Empty(t, option.Path, option.Argument)
+			found++
+		}
+	}
+	assert.Equal(t, len(disabled), found)
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/client"
+	"github.com/opsgenie/opsgenie-go-sdk-v2/heartbeat"
+	"github.com/opsgenie/opsgenie-go-sdk-v2/maintenance"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-go/types"
+)
+
+// maintenanceMarker starts the description of maintenance windows created by sync-maintenance,
+// windows without it are never updated or deleted
+const maintenanceMarker = "[managed-by:sensu-opsgenie-handler]"
+
+// MaintenanceAPI represents the OpsGenie maintenance operations used by sync-maintenance
+type MaintenanceAPI interface {
+	Create(ctx context.Context, req *maintenance.CreateRequest) (*maintenance.CreateResult, error)
+	Update(ctx context.Context, req *maintenance.UpdateRequest) (*maintenance.UpdateResult, error)
+	Delete(ctx context.Context, req *maintenance.DeleteRequest) (*maintenance.DeleteResult, error)
+	Cancel(ctx context.Context, req *maintenance.CancelRequest) (*maintenance.CloseResult, error)
+	List(ctx context.Context, req *maintenance.ListRequest) (*maintenance.ListResult, error)
+}
+
+// HeartbeatStateAPI represents the OpsGenie heartbeat operations used by sync-maintenance
+type HeartbeatStateAPI interface {
+	Get(ctx context.Context, heartbeatName string) (*heartbeat.GetResult, error)
+	Enable(ctx context.Context, heartbeatName string) (*heartbeat.HeartbeatInfo, error)
+	Disable(ctx context.Context, heartbeatName string) (*heartbeat.HeartbeatInfo, error)
+}
+
+var (
+	// newMaintenanceClient func creates the maintenance client used by sync-maintenance, tests can replace it
+	newMaintenanceClient = func(config *client.Config) (MaintenanceAPI, error) {
+		return maintenance.NewClient(config)
+	}
+	// newHeartbeatStateClient func creates the heartbeat client used by sync-maintenance, tests can replace it
+	newHeartbeatStateClient = func(config *client.Config) (HeartbeatStateAPI, error) {
+		return heartbeat.NewClient(config)
+	}
+)
+
+// maintenanceScope represents one --maintenance-integrations entry: silences of namespace, subscription
+// and check matching its path.Match patterns disable the integration
+type maintenanceScope struct {
+	namespace    string
+	subscription string
+	check        string
+	integration  string
+}
+
+// managedWindow represents a maintenance window of a Sensu silence, the integrations it disables
+// and the heartbeats sync-maintenance disabled for it
+type managedWindow struct {
+	id           string
+	status       string
+	silence      string
+	time         maintenance.Time
+	integrations []string
+	heartbeats   []string
+}
+
+// description func returns the maintenance description with the marker, the silence, the integrations and the disabled heartbeats
+func (w managedWindow) description() string {
+	description := fmt.Sprintf("%s Sensu silence %s", maintenanceMarker, w.silence)
+	if len(w.integrations) != 0 {
+		description += " integrations=" + strings.Join(w.integrations, ",")
+	}
+	if len(w.heartbeats) != 0 {
+		description += " heartbeats=" + strings.Join(w.heartbeats, ",")
+	}
+	return description
+}
+
+// parseManagedWindow func returns the managed window of a maintenance, false for windows not created by sync-maintenance
+func parseManagedWindow(m maintenance.Maintenance) (managedWindow, bool) {
+	rest := strings.TrimPrefix(m.Description, maintenanceMarker+" Sensu silence ")
+	if rest == m.Description {
+		return managedWindow{}, false
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return managedWindow{}, false
+	}
+	w := managedWindow{id: m.Id, status: m.Status, silence: fields[0], time: m.Time}
+	for _, v := range fields[1:] {
+		if ids := strings.TrimPrefix(v, "integrations="); ids != v {
+			w.integrations = appendUnique(w.integrations, strings.Split(ids, ",")...)
+		}
+		if names := strings.TrimPrefix(v, "heartbeats="); names != v {
+			w.heartbeats = appendUnique(w.heartbeats, strings.Split(names, ",")...)
+		}
+	}
+	return w, true
+}
+
+// rules func returns the maintenance rules disabling the integrations of the window
+func (w managedWindow) rules() []maintenance.Rule {
+	rules := []maintenance.Rule{}
+	for _, id := range w.integrations {
+		rules = append(rules, maintenance.Rule{State: maintenance.Disabled, Entity: maintenance.Entity{Id: id, Type: maintenance.Integration}})
+	}
+	return rules
+}
+
+// parseMaintenanceIntegrations func parses --maintenance-integrations entries like
+// default/entity:webserver01:*=integration-id or linux:check-cpu=integration-id, without namespace/ any namespace matches.
+// Subscription and check are split at the last colon, like in Sensu silence names
+func parseMaintenanceIntegrations(s string) ([]maintenanceScope, error) {
+	scopes := []maintenanceScope{}
+	for _, v := range splitStringInSlice(s) {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		scope, integration := splitString(v, "=")
+		if scope == "" || strings.TrimSpace(integration) == "" {
+			return nil, fmt.Errorf("maintenance integrations wrong format %q: [namespace/]subscription:check=integration-id", v)
+		}
+		m := maintenanceScope{namespace: "*", integration: strings.TrimSpace(integration)}
+		if i := strings.Index(scope, "/"); i != -1 {
+			m.namespace, scope = scope[:i], scope[i+1:]
+		}
+		i := strings.LastIndex(scope, ":")
+		if i == -1 {
+			return nil, fmt.Errorf("maintenance integrations wrong format %q: [namespace/]subscription:check=integration-id", v)
+		}
+		m.subscription, m.check = scope[:i], scope[i+1:]
+		for _, pattern := range []string{m.namespace, m.subscription, m.check} {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return nil, fmt.Errorf("maintenance integrations invalid scope %q: [namespace/]subscription:check=integration-id", v)
+			}
+		}
+		scopes = append(scopes, m)
+	}
+	return scopes, nil
+}
+
+// matches func returns true if the silence namespace, subscription and check match the scope.
+// Empty silence subscription or check are matched as *, so only a * scope matches them
+func (m maintenanceScope) matches(s sensuSilence) bool {
+	namespace := s.Metadata.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	return matchAny([]string{m.namespace}, namespace) &&
+		matchAny([]string{m.subscription}, orWildcard(s.Subscription)) &&
+		matchAny([]string{m.check}, orWildcard(s.Check))
+}
+
+// silenceIntegrations func returns the integrations of every scope matching the silence, sorted
+func silenceIntegrations(s sensuSilence, scopes []maintenanceScope) []string {
+	ids := []string{}
+	for _, m := range scopes {
+		if m.matches(s) {
+			ids = appendUnique(ids, m.integration)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// silenceKey func returns namespace/name of a silence
+func silenceKey(s sensuSilence) string {
+	name := s.Metadata.Name
+	if name == "" {
+		name = fmt.Sprintf("%s:%s", orWildcard(s.Subscription), orWildcard(s.Check))
+	}
+	namespace := s.Metadata.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	return namespace + "/" + name
+}
+
+// orWildcard func returns * for empty silence subscription or check
+func orWildcard(s string) string {
+	if s == "" {
+		return "*"
+	}
+	return s
+}
+
+// silenceWindow func returns the maintenance time of a silence, false if the silence should not have a window:
+// expired silences and silences without expiry that did not begin yet
+func silenceWindow(s sensuSilence) (maintenance.Time, bool) {
+	start := now().Truncate(time.Second)
+	if s.Begin > 0 {
+		start = time.Unix(s.Begin, 0)
+	}
+	end := s.expiry()
+	if end.IsZero() {
+		if start.After(now()) {
+			return maintenance.Time{}, false
+		}
+		return maintenance.Time{Type: maintenance.Indefinitely}, true
+	}
+	if !end.After(now()) || !end.After(start) {
+		return maintenance.Time{}, false
+	}
+	start, end = start.UTC(), end.UTC()
+	return maintenance.Time{Type: maintenance.Schedule, StartDate: &start, EndDate: &end}, true
+}
+
+// sameWindowTime func returns true if both times are the same to the second
+func sameWindowTime(a, b maintenance.Time) bool {
+	sameDate := func(x, y *time.Time) bool {
+		if x == nil || y == nil {
+			return x == nil && y == nil
+		}
+		return x.Unix() == y.Unix()
+	}
+	return a.Type == b.Type && sameDate(a.StartDate, b.StartDate) && sameDate(a.EndDate, b.EndDate)
+}
+
+// silenceHeartbeats func returns the --hearbeat-map heartbeats silenced by s. Only entity subscriptions
+// and * are matched, as other subscriptions of an entity are not known. all in the map only matches *
+func silenceHeartbeats(s sensuSilence, heartbeats map[string]string) []string {
+	entity := ""
+	subscription := orWildcard(s.Subscription)
+	switch {
+	case subscription == "*":
+		entity = "*"
+	case strings.HasPrefix(subscription, "entity:"):
+		entity = strings.TrimPrefix(subscription, "entity:")
+	default:
+		return nil
+	}
+	check := orWildcard(s.Check)
+	names := []string{}
+	for key, name := range heartbeats {
+		keyEntity, keyCheck := splitString(key, "/")
+		if keyEntity == "" {
+			keyEntity, keyCheck = key, key
+		}
+		if (entity == "*" || entity == keyEntity) && (check == "*" || check == keyCheck) {
+			names = appendUnique(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readSilencesFile func reads silences exported by sensuctl silenced list --format json or wrapped-json,
+// a JSON array or a stream of objects
+func readSilencesFile(file string) ([]sensuSilence, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read --silences-file: %s", err)
+	}
+	raws := []json.RawMessage{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for decoder.More() {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("invalid --silences-file: %s", err)
+		}
+		if trimmed := bytes.TrimSpace(raw); len(trimmed) != 0 && trimmed[0] == '[' {
+			list := []json.RawMessage{}
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return nil, fmt.Errorf("invalid --silences-file: %s", err)
+			}
+			raws = append(raws, list...)
+			continue
+		}
+		raws = append(raws, raw)
+	}
+	silences := []sensuSilence{}
+	for _, raw := range raws {
+		wrapped := struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Spec json.RawMessage `json:"spec"`
+		}{}
+		if err := json.Unmarshal(raw, &wrapped); err != nil {
+			return nil, fmt.Errorf("invalid --silences-file: %s", err)
+		}
+		silence := sensuSilence{}
+		spec := raw
+		if len(wrapped.Spec) != 0 {
+			spec = wrapped.Spec
+		}
+		if err := json.Unmarshal(spec, &silence); err != nil {
+			return nil, fmt.Errorf("invalid --silences-file: %s", err)
+		}
+		if wrapped.Metadata.Name != "" {
+			silence.Metadata = wrapped.Metadata
+		}
+		// expire is the time left when the file was exported, the end computed from it would move on every sync
+		if silence.Expire > 0 && silence.ExpireAt == 0 {
+			return nil, fmt.Errorf("invalid --silences-file: silence %s expires without expire_at, export it with a Sensu version that sets expire_at", silenceKey(silence))
+		}
+		silences = append(silences, silence)
+	}
+	return silences, nil
+}
+
+// listSilences func reads silences from --silences-file, or from every namespace of Sensu API
+func listSilences(ctx context.Context) ([]sensuSilence, error) {
+	if plugin.SilencesFile != "" {
+		return readSilencesFile(plugin.SilencesFile)
+	}
+	silences := []sensuSilence{}
+	if err := sensuGet(ctx, "/api/core/v2/silenced", &silences); err != nil {
+		return nil, fmt.Errorf("cannot list silences: %s", err)
+	}
+	return silences, nil
+}
+
+// checkMaintenanceArgs func validates the sync-maintenance subcommand configuration
+func checkMaintenanceArgs(_ *types.Event) (int, error) {
+	if len(plugin.AuthToken) == 0 {
+		return sensu.CheckStateUnknown, fmt.Errorf("authentication token is empty")
+	}
+	scopes, err := parseMaintenanceIntegrations(plugin.MaintenanceIntegrations)
+	if err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	if len(scopes) == 0 {
+		return sensu.CheckStateUnknown, fmt.Errorf("--maintenance-integrations is empty")
+	}
+	if plugin.SilencesFile == "" && plugin.SensuAPIURL == "" {
+		return sensu.CheckStateUnknown, fmt.Errorf("--silences-file or --sensu-api-url is required")
+	}
+	if err := checkSensuAPI(); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	if _, err := parseHeartbeatMap(plugin.HeartbeatMap); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	if err := checkTimeouts(); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	if err := checkBackend(); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	if _, err := httpClient(); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	return sensu.CheckStateOK, nil
+}
+
+// executeSyncMaintenance func creates, updates and removes managed maintenance windows to match Sensu silences,
+// it returns warning if some windows or heartbeats could not be synced
+func executeSyncMaintenance(_ *types.Event) (int, error) {
+	ctx, cancel := handlerContext()
+	defer cancel()
+	silences, err := listSilences(ctx)
+	if err != nil {
+		return sensu.CheckStateCritical, err
+	}
+	config, err := opsgenieConfig(ctx)
+	if err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	maintenanceClient, err := newMaintenanceClient(config)
+	if err != nil {
+		return sensu.CheckStateUnknown, fmt.Errorf("failed to create opsgenie maintenance client: %s", err)
+	}
+	heartbeatClient, err := newHeartbeatStateClient(config)
+	if err != nil {
+		return sensu.CheckStateUnknown, fmt.Errorf("failed to create opsgenie heartbeat client: %s", err)
+	}
+	failed, err := syncMaintenance(ctx, maintenanceClient, heartbeatClient, silences)
+	if err != nil {
+		return sensu.CheckStateCritical, err
+	}
+	if failed != 0 {
+		return sensu.CheckStateWarning, nil
+	}
+	return sensu.CheckStateOK, nil
+}
+
+// syncMaintenance func makes managed maintenance windows match silences and returns how many operations failed.
+// Each window disables the --maintenance-integrations of the scopes matching its silence, silences matching
+// no scope get no window. Heartbeats of --hearbeat-map silenced by an active window are disabled if enabled, and enabled again when no
+// managed window holds them, also when the window expired or was cancelled in OpsGenie. Windows and heartbeats
+// not changed by sync-maintenance are never touched
+func syncMaintenance(ctx context.Context, maintenanceClient MaintenanceAPI, heartbeatClient HeartbeatStateAPI, silences []sensuSilence) (int, error) {
+	var list *maintenance.ListResult
+	err := withRetry(ctx, "list maintenance", func(ctx context.Context) (err error) {
+		list, err = maintenanceClient.List(ctx, &maintenance.ListRequest{Type: maintenance.All})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	existing := make(map[string]managedWindow)
+	expired := []managedWindow{}
+	for _, m := range list.Maintenances {
+		w, ok := parseManagedWindow(m)
+		switch {
+		case !ok:
+		case m.Status == "past" || m.Status == "cancelled":
+			expired = append(expired, w)
+		default:
+			existing[w.silence] = w
+		}
+	}
+	heartbeats, _ := parseHeartbeatMap(plugin.HeartbeatMap)
+	scopes, err := parseMaintenanceIntegrations(plugin.MaintenanceIntegrations)
+	if err != nil {
+		return 0, err
+	}
+
+	failed := 0
+	desired := make(map[string]bool)
+	held := make(map[string]bool)
+	for _, s := range silences {
+		windowTime, ok := silenceWindow(s)
+		if !ok {
+			continue
+		}
+		key := silenceKey(s)
+		integrations := silenceIntegrations(s, scopes)
+		if len(integrations) == 0 {
+			fmt.Printf("Silence %s matches no --maintenance-integrations scope, no maintenance window \n", key)
+			continue
+		}
+		desired[key] = true
+		w, found := existing[key]
+		recorded := w.heartbeats
+		changed := !found || !sameWindowTime(w.time, windowTime) || strings.Join(w.integrations, ",") != strings.Join(integrations, ",")
+		w.silence, w.time, w.integrations = key, windowTime, integrations
+		disabled := []string{}
+		if windowTime.StartDate == nil || !windowTime.StartDate.After(now()) {
+			for _, name := range silenceHeartbeats(s, heartbeats) {
+				if containsString(w.heartbeats, name) {
+					continue
+				}
+				done, err := disableHeartbeat(ctx, heartbeatClient, name)
+				if err != nil {
+					fmt.Printf("[ERROR] %s \n", err)
+					failed++
+					continue
+				}
+				if done {
+					disabled = append(disabled, name)
+					w.heartbeats = appendUnique(w.heartbeats, name)
+					changed = true
+				}
+			}
+		}
+		if changed {
+			if err := saveWindow(ctx, maintenanceClient, w, found); err != nil {
+				// heartbeats disabled in this run are recorded nowhere, enable them again
+				fmt.Printf("[ERROR] %s \n", err)
+				failed++
+				failed += enableHeartbeats(ctx, heartbeatClient, disabled, held)
+				w.heartbeats = recorded
+			}
+		} else {
+			fmt.Printf("Maintenance %s of silence %s is up to date \n", w.id, key)
+		}
+		for _, name := range w.heartbeats {
+			held[name] = true
+		}
+	}
+
+	for _, key := range sortedKeys(existing) {
+		w := existing[key]
+		if desired[key] {
+			continue
+		}
+		if err := removeWindow(ctx, maintenanceClient, w); err != nil {
+			fmt.Printf("[ERROR] %s \n", err)
+			failed++
+			continue
+		}
+		failed += enableHeartbeats(ctx, heartbeatClient, w.heartbeats, held)
+	}
+
+	// expired and cancelled windows are deleted once their heartbeats are enabled, so it is done only once
+	for _, w := range expired {
+		if len(w.heartbeats) == 0 {
+			continue
+		}
+		if n := enableHeartbeats(ctx, heartbeatClient, w.heartbeats, held); n != 0 {
+			failed += n
+			continue
+		}
+		if err := removeWindow(ctx, maintenanceClient, w); err != nil {
+			fmt.Printf("[ERROR] %s \n", err)
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+// enableHeartbeats func enables heartbeats not held by a managed window and returns how many failed
+func enableHeartbeats(ctx context.Context, heartbeatClient HeartbeatStateAPI, names []string, held map[string]bool) int {
+	failed := 0
+	for _, name := range names {
+		if held[name] {
+			continue
+		}
+		if err := withRetry(ctx, "enable heartbeat "+name, func(ctx context.Context) error {
+			_, err := heartbeatClient.Enable(ctx, name)
+			return err
+		}); err != nil {
+			fmt.Printf("[ERROR] %s \n", err)
+			failed++
+			continue
+		}
+		fmt.Printf("Enabled heartbeat %s \n", name)
+	}
+	return failed
+}
+
+// disableHeartbeat func disables an enabled heartbeat and returns true, heartbeats already disabled are left alone
+func disableHeartbeat(ctx context.Context, heartbeatClient HeartbeatStateAPI, name string) (bool, error) {
+	var current *heartbeat.GetResult
+	err := withRetry(ctx, "get heartbeat "+name, func(ctx context.Context) (err error) {
+		current, err = heartbeatClient.Get(ctx, name)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if !current.Enabled {
+		fmt.Printf("Heartbeat %s already disabled \n", name)
+		return false, nil
+	}
+	err = withRetry(ctx, "disable heartbeat "+name, func(ctx context.Context) error {
+		_, err := heartbeatClient.Disable(ctx, name)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("Disabled heartbeat %s \n", name)
+	return true, nil
+}
+
+// saveWindow func creates a managed window, or updates it if found
+func saveWindow(ctx context.Context, maintenanceClient MaintenanceAPI, w managedWindow, found bool) error {
+	if !found {
+		var created *maintenance.CreateResult
+		err := withRetry(ctx, "create maintenance for silence "+w.silence, func(ctx context.Context) (err error) {
+			created, err = maintenanceClient.Create(ctx, &maintenance.CreateRequest{Description: w.description(), Time: w.time, Rules: w.rules()})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created maintenance %s for silence %s \n", created.Id, w.silence)
+		return nil
+	}
+	err := withRetry(ctx, "update maintenance "+w.id, func(ctx context.Context) error {
+		_, err := maintenanceClient.Update(ctx, &maintenance.UpdateRequest{Id: w.id, Description: w.description(), Time: w.time, Rules: w.rules()})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Updated maintenance %s for silence %s \n", w.id, w.silence)
+	return nil
+}
+
+// removeWindow func cancels an active managed window or deletes a planned one
+func removeWindow(ctx context.Context, maintenanceClient MaintenanceAPI, w managedWindow) error {
+	if w.status == "active" {
+		err := withRetry(ctx, "cancel maintenance "+w.id, func(ctx context.Context) error {
+			_, err := maintenanceClient.Cancel(ctx, &maintenance.CancelRequest{Id: w.id})
+			return err
+		})
+		if err == nil {
+			fmt.Printf("Cancelled maintenance %s of silence %s \n", w.id, w.silence)
+		}
+		return err
+	}
+	err := withRetry(ctx, "delete maintenance "+w.id, func(ctx context.Context) error {
+		_, err := maintenanceClient.Delete(ctx, &maintenance.DeleteRequest{Id: w.id})
+		return err
+	})
+	if err == nil {
+		fmt.Printf("Deleted maintenance %s of silence %s \n", w.id, w.silence)
+	}
+	return err
+}
+
+// sortedKeys func returns the keys of managed windows in order
+func sortedKeys(windows map[string]managedWindow) []string {
+	keys := []string{}
+	for k := range windows {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
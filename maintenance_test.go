@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betorvs/sensu-opsgenie-handler/mockserver"
+	"github.com/opsgenie/opsgenie-go-sdk-v2/client"
+	"github.com/opsgenie/opsgenie-go-sdk-v2/maintenance"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/stretchr/testify/assert"
+)
+
+// useMaintenance sets --maintenance-integrations, --hearbeat-map and a --silences-file with content until the test ends
+func useMaintenance(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "silences.json")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	plugin.SilencesFile = file
+	plugin.MaintenanceIntegrations = "default/entity:*:*=integration1,entity:entity1:*=integration2"
+	plugin.HeartbeatMap = "entity1/check1=hb1,entity2/check1=hb2,default=hb-all"
+	t.Cleanup(func() {
+		plugin.SilencesFile = ""
+		plugin.MaintenanceIntegrations = ""
+		plugin.HeartbeatMap = ""
+	})
+	return file
+}
+
+func TestReadSilencesFile(t *testing.T) {
+	file := useMaintenance(t, `[{"metadata":{"name":"entity:entity1:*","namespace":"default"},"subscription":"entity:entity1","expire":-1,"begin":1627984800}]`)
+	silences, err := readSilencesFile(file)
+	assert.NoError(t, err)
+	assert.Len(t, silences, 1)
+	assert.Equal(t, "default/entity:entity1:*", silenceKey(silences[0]))
+	assert.Equal(t, "entity:entity1", silences[0].Subscription)
+
+	// sensuctl --format wrapped-json writes a stream of objects with metadata and spec
+	wrapped := `{"type":"Silenced","api_version":"core/v2","spec":{"metadata":{"name":"*:check1","namespace":"prod"},"check":"check1","expire_at":1628000000}}
+{"type":"Silenced","api_version":"core/v2","metadata":{"name":"entity:entity2:*","namespace":"default"},"spec":{"subscription":"entity:entity2"}}`
+	assert.NoError(t, ioutil.WriteFile(file, []byte(wrapped), 0600))
+	silences, err = readSilencesFile(file)
+	assert.NoError(t, err)
+	assert.Len(t, silences, 2)
+	assert.Equal(t, "prod/*:check1", silenceKey(silences[0]))
+	assert.Equal(t, int64(1628000000), silences[0].ExpireAt)
+	assert.Equal(t, "default/entity:entity2:*", silenceKey(silences[1]))
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`[{"expire":"soon"}]`), 0600))
+	_, err = readSilencesFile(file)
+	assert.Error(t, err)
+
+	// an expiring silence needs expire_at, expire alone is only the time left at export
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`[{"metadata":{"name":"entity:entity1:*"},"subscription":"entity:entity1","expire":3600}]`), 0600))
+	_, err = readSilencesFile(file)
+	assert.Error(t, err)
+}
+
+func TestSilenceWindow(t *testing.T) {
+	now = func() time.Time { return time.Date(2021, 8, 3, 10, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+	windowTime, ok := silenceWindow(sensuSilence{Expire: 3600})
+	assert.True(t, ok)
+	assert.Equal(t, maintenance.Schedule, windowTime.Type)
+	assert.Equal(t, time.Date(2021, 8, 3, 11, 0, 0, 0, time.UTC), *windowTime.EndDate)
+	windowTime, ok = silenceWindow(sensuSilence{Expire: -1})
+	assert.True(t, ok)
+	assert.Equal(t, maintenance.Indefinitely, windowTime.Type)
+	_, ok = silenceWindow(sensuSilence{Expire: -1, Begin: time.Date(2021, 8, 3, 12, 0, 0, 0, time.UTC).Unix()})
+	assert.False(t, ok)
+	_, ok = silenceWindow(sensuSilence{ExpireAt: time.Date(2021, 8, 3, 9, 0, 0, 0, time.UTC).Unix()})
+	assert.False(t, ok)
+}
+
+func TestSilenceHeartbeats(t *testing.T) {
+	heartbeats, _ := parseHeartbeatMap("entity1/check1=hb1,entity2/check1=hb2,default=hb-all")
+	assert.Equal(t, []string{"hb1"}, silenceHeartbeats(sensuSilence{Subscription: "entity:entity1"}, heartbeats))
+	assert.Equal(t, []string{"hb1", "hb2"}, silenceHeartbeats(sensuSilence{Check: "check1"}, heartbeats))
+	assert.Equal(t, []string{"hb-all", "hb1", "hb2"}, silenceHeartbeats(sensuSilence{}, heartbeats))
+	assert.Empty(t, silenceHeartbeats(sensuSilence{Subscription: "linux"}, heartbeats))
+}
+
+func TestParseMaintenanceIntegrations(t *testing.T) {
+	scopes, err := parseMaintenanceIntegrations("default/entity:*:*=integration1,linux:check-cpu=integration2,*:*=integration3")
+	assert.NoError(t, err)
+	assert.Equal(t, []maintenanceScope{
+		{namespace: "default", subscription: "entity:*", check: "*", integration: "integration1"},
+		{namespace: "*", subscription: "linux", check: "check-cpu", integration: "integration2"},
+		{namespace: "*", subscription: "*", check: "*", integration: "integration3"},
+	}, scopes)
+
+	invalid := []string{
+		"integration1",
+		"linux=integration1",
+		"linux:check-cpu=",
+		"default/:*=integration1",
+		"default/linux:[=integration1",
+	}
+	for _, v := range invalid {
+		_, err := parseMaintenanceIntegrations(v)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestSilenceIntegrations(t *testing.T) {
+	scopes, _ := parseMaintenanceIntegrations("default/entity:*:*=integration1,linux:*=integration2")
+	entity := sensuSilence{Subscription: "entity:entity1"}
+	entity.Metadata.Namespace = "default"
+	assert.Equal(t, []string{"integration1"}, silenceIntegrations(entity, scopes))
+	assert.Equal(t, []string{"integration2"}, silenceIntegrations(sensuSilence{Subscription: "linux", Check: "check-cpu"}, scopes))
+	entity.Metadata.Namespace = "prod"
+	assert.Empty(t, silenceIntegrations(entity, scopes))
+
+	// a check silenced for every subscription and unscoped silences only match * scopes
+	assert.Empty(t, silenceIntegrations(sensuSilence{Check: "check-cpu"}, scopes))
+	assert.Empty(t, silenceIntegrations(sensuSilence{}, scopes))
+	scopes, _ = parseMaintenanceIntegrations("linux:*=integration2,*:*=integration3")
+	assert.Equal(t, []string{"integration2", "integration3"}, silenceIntegrations(sensuSilence{Subscription: "linux"}, scopes))
+	assert.Equal(t, []string{"integration3"}, silenceIntegrations(sensuSilence{}, scopes))
+}
+
+func TestCheckMaintenanceArgs(t *testing.T) {
+	plugin.AuthToken = "token"
+	defer func() { plugin.AuthToken = "" }()
+	status, err := checkMaintenanceArgs(nil)
+	assert.Error(t, err)
+	assert.Equal(t, sensu.CheckStateUnknown, status)
+	useMaintenance(t, "[]")
+	status, err = checkMaintenanceArgs(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, sensu.CheckStateOK, status)
+	plugin.SilencesFile = ""
+	_, err = checkMaintenanceArgs(nil)
+	assert.Error(t, err)
+
+	// integration IDs without a silence scope are refused
+	plugin.SilencesFile = "silences.json"
+	plugin.MaintenanceIntegrations = "integration1,integration2"
+	_, err = checkMaintenanceArgs(nil)
+	assert.Error(t, err)
+}
+
+func TestSyncMaintenanceEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	server.AddHeartbeat("hb1", true)
+	server.AddHeartbeat("hb2", false)
+	end := time.Now().Add(time.Hour).UTC()
+	manual := server.AddMaintenance(mockserver.Maintenance{
+		Description: "database upgrade",
+		Time:        mockserver.MaintenanceTime{Type: "indefinitely"},
+	})
+	file := useMaintenance(t, fmt.Sprintf(`[
+		{"metadata":{"name":"entity:entity1:*","namespace":"default"},"subscription":"entity:entity1","expire_at":%d},
+		{"metadata":{"name":"entity:entity2:*","namespace":"default"},"subscription":"entity:entity2","expire":-1},
+		{"metadata":{"name":"linux:check1","namespace":"default"},"subscription":"linux","check":"check1","expire":-1}
+	]`, end.Unix()))
+
+	status, err := executeSyncMaintenance(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, sensu.CheckStateOK, status)
+	windows := server.Maintenances()
+	assert.Len(t, windows, 3)
+	managed := map[string]mockserver.Maintenance{}
+	for _, m := range windows {
+		if strings.HasPrefix(m.Description, maintenanceMarker) {
+			managed[m.Description] = m
+		}
+	}
+	entity1, ok := managed[maintenanceMarker+" Sensu silence default/entity:entity1:* integrations=integration1,integration2 heartbeats=hb1"]
+	assert.True(t, ok)
+	assert.Equal(t, "active", entity1.Status)
+	assert.Equal(t, end.Unix(), entity1.Time.EndDate.Unix())
+	assert.Len(t, entity1.Rules, 2)
+	assert.Equal(t, "integration", entity1.Rules[0].Entity.Type)
+	// hb2 was already disabled, it is not recorded and will not be enabled
+	entity2, ok := managed[maintenanceMarker+" Sensu silence default/entity:entity2:* integrations=integration1"]
+	assert.True(t, ok)
+	assert.Len(t, entity2.Rules, 1)
+	// the linux silence matches no scope and gets no window
+	enabled, _ := server.HeartbeatEnabled("hb1")
+	assert.False(t, enabled)
+
+	// a second run changes nothing
+	count := len(server.Maintenances())
+	requests := len(server.Requests())
+	status, err = executeSyncMaintenance(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, sensu.CheckStateOK, status)
+	assert.Len(t, server.Maintenances(), count)
+	for _, request := range server.Requests()[requests:] {
+		assert.Equal(t, "GET", request.Method, request.Path)
+	}
+
+	// removed silences cancel their windows and enable their heartbeats again, the manual window is kept
+	assert.NoError(t, ioutil.WriteFile(file, []byte("[]"), 0600))
+	status, err = executeSyncMaintenance(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, sensu.CheckStateOK, status)
+	for _, m := range server.Maintenances() {
+		if m.ID == manual {
+			assert.Equal(t, "active", m.Status)
+			continue
+		}
+		assert.Equal(t, "cancelled", m.Status)
+	}
+	enabled, _ = server.HeartbeatEnabled("hb1")
+	assert.True(t, enabled)
+	enabled, _ = server.HeartbeatEnabled("hb2")
+	assert.False(t, enabled)
+}
+
+func TestSyncMaintenanceExpiredWindow(t *testing.T) {
+	server := useMockServer(t)
+	server.AddHeartbeat("hb1", false)
+	server.AddHeartbeat("hb2", false)
+	start, end := time.Now().Add(-2*time.Hour).UTC(), time.Now().Add(-time.Hour).UTC()
+	server.AddMaintenance(mockserver.Maintenance{
+		Description: maintenanceMarker + " Sensu silence default/entity:entity1:* heartbeats=hb1,hb2",
+		Time:        mockserver.MaintenanceTime{Type: "schedule", StartDate: &start, EndDate: &end},
+	})
+	// hb2 is held by the window of an active silence
+	useMaintenance(t, `[{"metadata":{"name":"entity:entity2:*","namespace":"default"},"subscription":"entity:entity2","expire":-1}]`)
+	server.AddMaintenance(mockserver.Maintenance{
+		Description: maintenanceMarker + " Sensu silence default/entity:entity2:* heartbeats=hb2",
+		Time:        mockserver.MaintenanceTime{Type: "indefinitely"},
+	})
+
+	status, err := executeSyncMaintenance(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, sensu.CheckStateOK, status)
+	enabled, _ := server.HeartbeatEnabled("hb1")
+	assert.True(t, enabled)
+	enabled, _ = server.HeartbeatEnabled("hb2")
+	assert.False(t, enabled)
+	// the expired window is deleted so its heartbeats are enabled only once
+	windows := server.Maintenances()
+	assert.Len(t, windows, 1)
+	assert.Equal(t, "active", windows[0].Status)
+}
+
+// failingCreate fails maintenance creation
+type failingCreate struct {
+	MaintenanceAPI
+}
+
+func (failingCreate) Create(ctx context.Context, req *maintenance.CreateRequest) (*maintenance.CreateResult, error) {
+	return nil, errors.New("create rejected")
+}
+
+func TestSyncMaintenanceSaveFailed(t *testing.T) {
+	server := useMockServer(t)
+	server.AddHeartbeat("hb1", true)
+	useMaintenance(t, `[{"metadata":{"name":"entity:entity1:*","namespace":"default"},"subscription":"entity:entity1","expire":-1}]`)
+	defaultClient := newMaintenanceClient
+	newMaintenanceClient = func(config *client.Config) (MaintenanceAPI, error) {
+		c, err := defaultClient(config)
+		return failingCreate{c}, err
+	}
+	defer func() { newMaintenanceClient = defaultClient }()
+
+	status, err := executeSyncMaintenance(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, sensu.CheckStateWarning, status)
+	assert.Empty(t, server.Maintenances())
+	// hb1 is not recorded by any window, it is enabled again
+	enabled, _ := server.HeartbeatEnabled("hb1")
+	assert.True(t, enabled)
+}
@@ -0,0 +1,188 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MaintenanceRule represents a maintenance rule, entity type is integration or policy
+type MaintenanceRule struct {
+	State  string `json:"state,omitempty"`
+	Entity struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	} `json:"entity"`
+}
+
+// MaintenanceTime represents when a maintenance applies
+type MaintenanceTime struct {
+	Type      string     `json:"type"`
+	StartDate *time.Time `json:"startDate,omitempty"`
+	EndDate   *time.Time `json:"endDate,omitempty"`
+}
+
+// Maintenance represents a maintenance window, status is computed from its time unless it was cancelled
+type Maintenance struct {
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Description string            `json:"description"`
+	Time        MaintenanceTime   `json:"time"`
+	Rules       []MaintenanceRule `json:"rules"`
+}
+
+// AddHeartbeat registers a heartbeat that can be read, enabled and disabled
+func (s *Server) AddHeartbeat(name string, enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enabled[name] = enabled
+}
+
+// HeartbeatEnabled returns if a heartbeat added with AddHeartbeat is enabled
+func (s *Server) HeartbeatEnabled(name string) (bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	enabled, ok := s.enabled[name]
+	return enabled, ok
+}
+
+// AddMaintenance saves a maintenance window, like one created by hand in OpsGenie, and returns its ID
+func (s *Server) AddMaintenance(m Maintenance) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.ID = s.nextID("maintenance")
+	s.windows[m.ID] = &m
+	return m.ID
+}
+
+// Maintenances returns a copy of every maintenance window, deleted ones are removed
+func (s *Server) Maintenances() []Maintenance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	windows := []Maintenance{}
+	for _, m := range s.windows {
+		windows = append(windows, s.withStatus(*m))
+	}
+	return windows
+}
+
+// withStatus returns the maintenance with the status OpsGenie would return now
+func (s *Server) withStatus(m Maintenance) Maintenance {
+	if m.Status == "cancelled" {
+		return m
+	}
+	now := time.Now()
+	switch {
+	case m.Time.StartDate != nil && now.Before(*m.Time.StartDate):
+		m.Status = "planned"
+	case m.Time.EndDate != nil && !now.Before(*m.Time.EndDate):
+		m.Status = "past"
+	default:
+		m.Status = "active"
+	}
+	return m
+}
+
+// handleHeartbeatState handles GET /v2/heartbeats/{name} and POST /v2/heartbeats/{name}/enable or disable
+func (s *Server) handleHeartbeatState(w http.ResponseWriter, r *http.Request, rest string) {
+	parts := strings.SplitN(rest, "/", 2)
+	name := parts[0]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	enabled, ok := s.enabled[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Heartbeat not found")
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+	case len(parts) == 2 && parts[1] == "enable" && r.Method == http.MethodPost:
+		enabled = true
+	case len(parts) == 2 && parts[1] == "disable" && r.Method == http.MethodPost:
+		enabled = false
+	default:
+		writeError(w, http.StatusNotFound, "Unknown heartbeat endpoint")
+		return
+	}
+	s.enabled[name] = enabled
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":      map[string]interface{}{"name": name, "enabled": enabled, "expired": false},
+		"took":      0.001,
+		"requestId": s.nextID("request"),
+	})
+}
+
+// handleMaintenances handles GET and POST /v1/maintenance
+func (s *Server) handleMaintenances(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		windows := []Maintenance{}
+		for _, m := range s.windows {
+			windows = append(windows, s.withStatus(*m))
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": windows, "took": 0.001, "requestId": s.nextID("request")})
+	case http.MethodPost:
+		m := &Maintenance{}
+		if !readMaintenance(w, r, m) {
+			return
+		}
+		m.ID = s.nextID("maintenance")
+		s.windows[m.ID] = m
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"data": s.withStatus(*m), "took": 0.001, "requestId": s.nextID("request")})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleMaintenance handles GET, PUT and DELETE /v1/maintenance/{id} and POST /v1/maintenance/{id}/cancel
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v1/maintenance/"), "/", 2)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found, ok := s.windows[parts[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Maintenance not found")
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		updated := &Maintenance{}
+		if !readMaintenance(w, r, updated) {
+			return
+		}
+		updated.ID = found.ID
+		s.windows[found.ID] = updated
+		found = updated
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		delete(s.windows, found.ID)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"result": "Deleted", "took": 0.001, "requestId": s.nextID("request")})
+		return
+	case len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost:
+		found.Status = "cancelled"
+		writeJSON(w, http.StatusOK, map[string]interface{}{"result": "Cancelled", "took": 0.001, "requestId": s.nextID("request")})
+		return
+	default:
+		writeError(w, http.StatusNotFound, "Unknown maintenance endpoint")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": s.withStatus(*found), "took": 0.001, "requestId": s.nextID("request")})
+}
+
+// readMaintenance decodes a maintenance request body, it writes 422 and returns false if it is invalid
+func readMaintenance(w http.ResponseWriter, r *http.Request, m *Maintenance) bool {
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, m); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return false
+	}
+	if len(m.Rules) == 0 || m.Time.Type == "" {
+		writeError(w, http.StatusUnprocessableEntity, "time and rules are required")
+		return false
+	}
+	return true
+}
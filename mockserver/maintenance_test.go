@@ -0,0 +1,63 @@
+package mockserver
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenanceLifecycle(t *testing.T) {
+	s := New()
+	defer s.Close()
+	start := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	end := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	code, result := do(t, s, http.MethodPost, "/v1/maintenance", `{"description":"test","time":{"type":"schedule","startDate":"`+start+`","endDate":"`+end+`"},"rules":[{"state":"disabled","entity":{"id":"integration1","type":"integration"}}]}`)
+	assert.Equal(t, http.StatusCreated, code)
+	id := result["data"].(map[string]interface{})["id"].(string)
+	assert.NotEmpty(t, id)
+
+	code, result = do(t, s, http.MethodGet, "/v1/maintenance", "")
+	assert.Equal(t, http.StatusOK, code)
+	list := result["data"].([]interface{})
+	assert.Len(t, list, 1)
+	assert.Equal(t, "active", list[0].(map[string]interface{})["status"])
+
+	code, _ = do(t, s, http.MethodPut, "/v1/maintenance/"+id, `{"description":"updated","time":{"type":"indefinitely"},"rules":[{"state":"disabled","entity":{"id":"integration1","type":"integration"}}]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "updated", s.Maintenances()[0].Description)
+
+	code, _ = do(t, s, http.MethodPost, "/v1/maintenance/"+id+"/cancel", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "cancelled", s.Maintenances()[0].Status)
+
+	code, _ = do(t, s, http.MethodDelete, "/v1/maintenance/"+id, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, s.Maintenances())
+	code, _ = do(t, s, http.MethodGet, "/v1/maintenance/"+id, "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestHeartbeatState(t *testing.T) {
+	s := New()
+	defer s.Close()
+	s.AddHeartbeat("hb1", true)
+	code, result := do(t, s, http.MethodGet, "/v2/heartbeats/hb1", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, result["data"].(map[string]interface{})["enabled"])
+
+	code, _ = do(t, s, http.MethodPost, "/v2/heartbeats/hb1/disable", "")
+	assert.Equal(t, http.StatusOK, code)
+	enabled, ok := s.HeartbeatEnabled("hb1")
+	assert.True(t, ok)
+	assert.False(t, enabled)
+
+	code, _ = do(t, s, http.MethodPost, "/v2/heartbeats/hb1/enable", "")
+	assert.Equal(t, http.StatusOK, code)
+	enabled, _ = s.HeartbeatEnabled("hb1")
+	assert.True(t, enabled)
+
+	code, _ = do(t, s, http.MethodGet, "/v2/heartbeats/missing", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,496 @@
+// Package mockserver implements a local stand-in for the OpsGenie v2 alert and
+// heartbeat REST API and v1 maintenance REST API used by sensu-opsgenie-handler. Alerts are kept in memory
+// by alias and every request is saved in a request log, so the handler can be
+// tested end to end without OpsGenie.
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Responder represents an alert responder
+type Responder struct {
+	Type     string `json:"type,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// Alert represents an alert saved in the server
+type Alert struct {
+	ID           string            `json:"id"`
+	TinyID       string            `json:"tinyId"`
+	Alias        string            `json:"alias"`
+	Message      string            `json:"message"`
+	Description  string            `json:"description,omitempty"`
+	Status       string            `json:"status"`
+	Acknowledged bool              `json:"acknowledged"`
+	Snoozed      bool              `json:"snoozed"`
+	SnoozedUntil time.Time         `json:"snoozedUntil,omitempty"`
+	Count        int               `json:"count"`
+	Priority     string            `json:"priority"`
+	Tags         []string          `json:"tags,omitempty"`
+	Details      map[string]string `json:"details,omitempty"`
+	Responders   []Responder       `json:"responders,omitempty"`
+	VisibleTo    []Responder       `json:"visibleTo,omitempty"`
+	Actions      []string          `json:"actions,omitempty"`
+	Entity       string            `json:"entity,omitempty"`
+	Source       string            `json:"source,omitempty"`
+	Notes        []string          `json:"-"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	UpdatedAt    time.Time         `json:"updatedAt"`
+}
+
+// Request represents one request received by the server
+type Request struct {
+	Method        string
+	Path          string
+	Query         url.Values
+	Body          string
+	Authorization string
+}
+
+// requestStatus represents the result of an asynchronous alert request
+type requestStatus struct {
+	IsSuccess   bool      `json:"isSuccess"`
+	Action      string    `json:"action"`
+	ProcessedAt time.Time `json:"processedAt"`
+	Status      string    `json:"status"`
+	AlertID     string    `json:"alertId,omitempty"`
+	Alias       string    `json:"alias,omitempty"`
+}
+
+// Server is an in-memory OpsGenie API stand-in
+type Server struct {
+	mu         sync.Mutex
+	alerts     map[string]*Alert
+	aliases    map[string]string
+	statuses   map[string]requestStatus
+	heartbeats map[string]int
+	enabled    map[string]bool
+	windows    map[string]*Maintenance
+	requests   []Request
+	failures   []int
+	rejections []string
+	pending    int
+	delay      time.Duration
+	sequence   int
+	httpServer *httptest.Server
+}
+
+// NewServer returns a Server that is not listening, use Handler to serve it
+func NewServer() *Server {
+	return &Server{
+		alerts:     make(map[string]*Alert),
+		aliases:    make(map[string]string),
+		statuses:   make(map[string]requestStatus),
+		heartbeats: make(map[string]int),
+		enabled:    make(map[string]bool),
+		windows:    make(map[string]*Maintenance),
+	}
+}
+
+// New returns a Server listening on a local random port
+func New() *Server {
+	s := NewServer()
+	s.httpServer = httptest.NewServer(s.Handler())
+	return s
+}
+
+// Close stops the server started by New
+func (s *Server) Close() {
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+}
+
+// URL returns the server base URL, like http://127.0.0.1:8080
+func (s *Server) URL() string {
+	if s.httpServer == nil {
+		return ""
+	}
+	return s.httpServer.URL
+}
+
+// Host returns the server host and port, to be used as OpsGenie API URL
+func (s *Server) Host() string {
+	return strings.TrimPrefix(s.URL(), "http://")
+}
+
+// Alert returns a copy of the last alert created with alias
+func (s *Server) Alert(alias string) (Alert, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.aliases[alias]
+	if !ok {
+		return Alert{}, false
+	}
+	return *s.alerts[id], true
+}
+
+// Requests returns a copy of the request log
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// Pings returns how many times a heartbeat was pinged
+func (s *Server) Pings(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.heartbeats[name]
+}
+
+// FailNext answers the next count requests with code, like 503 or 429, to test retries
+func (s *Server) FailNext(count, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < count; i++ {
+		s.failures = append(s.failures, code)
+	}
+}
+
+// RejectNextCreate accepts the next create request with 202 but does not create the alert,
+// its request status fails with message, like OpsGenie does for an invalid responder
+func (s *Server) RejectNextCreate(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejections = append(s.rejections, message)
+}
+
+// PendingStatus answers the next count request status lookups with 404, like OpsGenie does
+// while a request is not processed yet
+func (s *Server) PendingStatus(count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending += count
+}
+
+// Delay waits d before answering every request, to test timeouts
+func (s *Server) Delay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Handler returns the http.Handler with OpsGenie v2 alert and heartbeat endpoints and v1 maintenance endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/alerts", s.handleCreate)
+	mux.HandleFunc("/v2/alerts/", s.handleAlert)
+	mux.HandleFunc("/v2/heartbeats/", s.handleHeartbeat)
+	mux.HandleFunc("/v1/maintenance", s.handleMaintenances)
+	mux.HandleFunc("/v1/maintenance/", s.handleMaintenance)
+	return s.logRequests(mux)
+}
+
+// logRequests saves every request in the request log and checks authorization header
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			Query:         r.URL.Query(),
+			Body:          string(body),
+			Authorization: r.Header.Get("Authorization"),
+		})
+		failure := 0
+		if len(s.failures) != 0 {
+			failure = s.failures[0]
+			s.failures = s.failures[1:]
+		}
+		delay := s.delay
+		s.mu.Unlock()
+		if delay != 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+		if failure != 0 {
+			writeError(w, failure, http.StatusText(failure))
+			return
+		}
+		if r.Header.Get("Authorization") == "" {
+			writeError(w, http.StatusUnauthorized, "Could not authenticate")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) nextID(prefix string) string {
+	s.sequence++
+	return fmt.Sprintf("%s-%d", prefix, s.sequence)
+}
+
+// handleCreate handles POST /v2/alerts, an open alert with the same alias is deduplicated
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req struct {
+		Alert
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if req.Message == "" {
+		writeError(w, http.StatusUnprocessableEntity, "Message can not be empty")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	if req.Alias == "" {
+		req.Alias = s.nextID("alias")
+	}
+	if len(s.rejections) != 0 {
+		message := s.rejections[0]
+		s.rejections = s.rejections[1:]
+		s.writeRejected(w, "Create", message, req.Alias)
+		return
+	}
+	if id, ok := s.aliases[req.Alias]; ok && s.alerts[id].Status == "open" {
+		existing := s.alerts[id]
+		existing.Count++
+		existing.UpdatedAt = now
+		s.writeAccepted(w, "Create", "Alert deduplicated", existing)
+		return
+	}
+	if req.Priority == "" {
+		req.Priority = "P3"
+	}
+	created := &Alert{
+		ID:          s.nextID("alert"),
+		Alias:       req.Alias,
+		Message:     req.Message,
+		Description: req.Description,
+		Status:      "open",
+		Count:       1,
+		Priority:    req.Priority,
+		Tags:        req.Tags,
+		Details:     req.Details,
+		Responders:  req.Responders,
+		VisibleTo:   req.VisibleTo,
+		Actions:     req.Actions,
+		Entity:      req.Entity,
+		Source:      req.Source,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	created.TinyID = strings.TrimPrefix(created.ID, "alert-")
+	if created.Details == nil {
+		created.Details = make(map[string]string)
+	}
+	if req.Note != "" {
+		created.Notes = append(created.Notes, req.Note)
+	}
+	s.alerts[created.ID] = created
+	s.aliases[created.Alias] = created.ID
+	s.writeAccepted(w, "Create", "Created alert", created)
+}
+
+// handleAlert handles /v2/alerts/{identifier} and /v2/alerts/{identifier}/{action}
+func (s *Server) handleAlert(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/v2/alerts/")
+	if strings.HasPrefix(rest, "requests/") && r.Method == http.MethodGet {
+		s.handleRequestStatus(w, strings.TrimPrefix(rest, "requests/"))
+		return
+	}
+	identifier, action := rest, ""
+	if r.Method != http.MethodGet {
+		if i := strings.LastIndex(rest, "/"); i != -1 {
+			identifier, action = rest[:i], rest[i+1:]
+		}
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := s.find(identifier, r.URL.Query().Get("identifierType"))
+	if found == nil {
+		writeError(w, http.StatusNotFound, "Alert does not exist")
+		return
+	}
+	if r.Method == http.MethodGet && action == "" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": found})
+		return
+	}
+
+	var req struct {
+		Note        string            `json:"note"`
+		Details     map[string]string `json:"details"`
+		Priority    string            `json:"priority"`
+		Message     string            `json:"message"`
+		Description string            `json:"description"`
+		Responder   Responder         `json:"responder"`
+		Tags        []string          `json:"tags"`
+		EndTime     time.Time         `json:"endTime"`
+	}
+	if len(body) != 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+	switch action {
+	case "close":
+		found.Status = "closed"
+		found.Snoozed = false
+	case "acknowledge":
+		found.Acknowledged = true
+	case "unacknowledge":
+		found.Acknowledged = false
+	case "snooze":
+		found.Snoozed = true
+		found.SnoozedUntil = req.EndTime
+	case "notes":
+	case "details":
+		for k, v := range req.Details {
+			found.Details[k] = v
+		}
+	case "priority":
+		found.Priority = req.Priority
+	case "message":
+		found.Message = req.Message
+	case "description":
+		found.Description = req.Description
+	case "responders":
+		found.Responders = append(found.Responders, req.Responder)
+	case "tags":
+		for _, tag := range req.Tags {
+			if !containsTag(found.Tags, tag) {
+				found.Tags = append(found.Tags, tag)
+			}
+		}
+	default:
+		writeError(w, http.StatusNotFound, "Unknown alert action "+action)
+		return
+	}
+	if req.Note != "" {
+		found.Notes = append(found.Notes, req.Note)
+	}
+	found.UpdatedAt = time.Now().UTC()
+	s.writeAccepted(w, strings.Title(action), "Request processed", found)
+}
+
+// containsTag returns true if tags has tag
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns an alert by id, tiny id or alias
+func (s *Server) find(identifier, identifierType string) *Alert {
+	switch identifierType {
+	case "alias":
+		if id, ok := s.aliases[identifier]; ok {
+			return s.alerts[id]
+		}
+	case "tiny":
+		for _, a := range s.alerts {
+			if a.TinyID == identifier {
+				return a
+			}
+		}
+	default:
+		return s.alerts[identifier]
+	}
+	return nil
+}
+
+// handleRequestStatus handles GET /v2/alerts/requests/{requestId}
+func (s *Server) handleRequestStatus(w http.ResponseWriter, requestID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.statuses[requestID]
+	if s.pending > 0 {
+		s.pending--
+		ok = false
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "Request not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": status})
+}
+
+// handleHeartbeat handles GET /v2/heartbeats/{name}/ping, and GET /v2/heartbeats/{name}
+// and POST /v2/heartbeats/{name}/enable or disable for heartbeats added with AddHeartbeat
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/v2/heartbeats/")
+	if !strings.HasSuffix(rest, "/ping") {
+		s.handleHeartbeatState(w, r, rest)
+		return
+	}
+	name := strings.TrimSuffix(rest, "/ping")
+	s.mu.Lock()
+	s.heartbeats[name]++
+	requestID := s.nextID("request")
+	s.mu.Unlock()
+	w.Header().Set("X-Request-Id", requestID)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"result": "PONG - Heartbeat received", "took": 0.001, "requestId": requestID})
+}
+
+// writeAccepted saves the request status and writes the asynchronous 202 response
+func (s *Server) writeAccepted(w http.ResponseWriter, action, status string, a *Alert) {
+	requestID := s.nextID("request")
+	s.statuses[requestID] = requestStatus{
+		IsSuccess:   true,
+		Action:      action,
+		ProcessedAt: time.Now().UTC(),
+		Status:      status,
+		AlertID:     a.ID,
+		Alias:       a.Alias,
+	}
+	w.Header().Set("X-Request-Id", requestID)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"result": "Request will be processed", "took": 0.001, "requestId": requestID})
+}
+
+// writeRejected saves a failed request status and writes the asynchronous 202 response
+func (s *Server) writeRejected(w http.ResponseWriter, action, message, alias string) {
+	requestID := s.nextID("request")
+	s.statuses[requestID] = requestStatus{
+		IsSuccess:   false,
+		Action:      action,
+		ProcessedAt: time.Now().UTC(),
+		Status:      message,
+		Alias:       alias,
+	}
+	w.Header().Set("X-Request-Id", requestID)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"result": "Request will be processed", "took": 0.001, "requestId": requestID})
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]interface{}{"message": message, "took": 0.001, "requestId": "error"})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Response-Time", "0.001")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,147 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func do(t *testing.T, s *Server, method, path, body string) (int, map[string]interface{}) {
+	req, err := http.NewRequest(method, s.URL()+path, strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "GenieKey test")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	result := make(map[string]interface{})
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	return resp.StatusCode, result
+}
+
+func TestCreateAndDeduplicate(t *testing.T) {
+	s := New()
+	defer s.Close()
+	code, result := do(t, s, http.MethodPost, "/v2/alerts", `{"message":"webserver01/check-nginx","alias":"webserver01/check-nginx","priority":"P2","note":"first"}`)
+	assert.Equal(t, http.StatusAccepted, code)
+	requestID, _ := result["requestId"].(string)
+	assert.NotEmpty(t, requestID)
+	code, _ = do(t, s, http.MethodPost, "/v2/alerts", `{"message":"webserver01/check-nginx","alias":"webserver01/check-nginx"}`)
+	assert.Equal(t, http.StatusAccepted, code)
+
+	created, ok := s.Alert("webserver01/check-nginx")
+	assert.True(t, ok)
+	assert.Equal(t, 2, created.Count)
+	assert.Equal(t, "P2", created.Priority)
+	assert.Equal(t, "open", created.Status)
+	assert.Equal(t, []string{"first"}, created.Notes)
+
+	code, result = do(t, s, http.MethodGet, "/v2/alerts/webserver01/check-nginx?identifierType=alias", "")
+	assert.Equal(t, http.StatusOK, code)
+	data := result["data"].(map[string]interface{})
+	assert.Equal(t, created.ID, data["id"])
+
+	code, result = do(t, s, http.MethodGet, "/v2/alerts/requests/"+requestID, "")
+	assert.Equal(t, http.StatusOK, code)
+	status := result["data"].(map[string]interface{})
+	assert.Equal(t, true, status["isSuccess"])
+	assert.Equal(t, created.ID, status["alertId"])
+}
+
+func TestAlertActions(t *testing.T) {
+	s := New()
+	defer s.Close()
+	do(t, s, http.MethodPost, "/v2/alerts", `{"message":"test","alias":"entity/check"}`)
+	created, _ := s.Alert("entity/check")
+
+	code, _ := do(t, s, http.MethodPut, "/v2/alerts/"+created.ID+"/priority?identifierType=id", `{"priority":"P1"}`)
+	assert.Equal(t, http.StatusAccepted, code)
+	do(t, s, http.MethodPost, "/v2/alerts/"+created.ID+"/details?identifierType=id", `{"details":{"status":"2"},"note":"changed"}`)
+	do(t, s, http.MethodPost, "/v2/alerts/"+created.ID+"/responders?identifierType=id", `{"responder":{"type":"team","name":"sre"}}`)
+	updated, _ := s.Alert("entity/check")
+	assert.Equal(t, "P1", updated.Priority)
+	assert.Equal(t, "2", updated.Details["status"])
+	assert.Equal(t, []string{"changed"}, updated.Notes)
+	assert.Equal(t, []Responder{{Type: "team", Name: "sre"}}, updated.Responders)
+
+	code, _ = do(t, s, http.MethodPost, "/v2/alerts/"+created.ID+"/close?identifierType=id", `{"note":"closed"}`)
+	assert.Equal(t, http.StatusAccepted, code)
+	closed, _ := s.Alert("entity/check")
+	assert.Equal(t, "closed", closed.Status)
+
+	// a closed alias creates a new alert
+	do(t, s, http.MethodPost, "/v2/alerts", `{"message":"test","alias":"entity/check"}`)
+	reopened, _ := s.Alert("entity/check")
+	assert.NotEqual(t, created.ID, reopened.ID)
+	assert.Equal(t, "open", reopened.Status)
+
+	code, result := do(t, s, http.MethodGet, "/v2/alerts/missing?identifierType=alias", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "Alert does not exist", result["message"])
+}
+
+func TestHeartbeatAndRequestLog(t *testing.T) {
+	s := New()
+	defer s.Close()
+	code, result := do(t, s, http.MethodGet, "/v2/heartbeats/heartbeat1/ping", "")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, "PONG - Heartbeat received", result["result"])
+	assert.Equal(t, 1, s.Pings("heartbeat1"))
+
+	resp, err := http.Get(s.URL() + "/v2/heartbeats/heartbeat1/ping")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	requests := s.Requests()
+	assert.Len(t, requests, 2)
+	assert.Equal(t, "GenieKey test", requests[0].Authorization)
+	assert.Equal(t, "/v2/heartbeats/heartbeat1/ping", requests[1].Path)
+}
+
+func TestFailNext(t *testing.T) {
+	s := New()
+	defer s.Close()
+	s.FailNext(2, http.StatusServiceUnavailable)
+	code, _ := do(t, s, http.MethodGet, "/v2/heartbeats/heartbeat1/ping", "")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	code, _ = do(t, s, http.MethodGet, "/v2/heartbeats/heartbeat1/ping", "")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	code, _ = do(t, s, http.MethodGet, "/v2/heartbeats/heartbeat1/ping", "")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, 1, s.Pings("heartbeat1"))
+	assert.Len(t, s.Requests(), 3)
+}
+
+func TestRejectNextCreateAndPendingStatus(t *testing.T) {
+	s := New()
+	defer s.Close()
+	s.RejectNextCreate("Invalid responder")
+	s.PendingStatus(1)
+	code, result := do(t, s, http.MethodPost, "/v2/alerts", `{"message":"webserver01/check-nginx","alias":"webserver01/check-nginx","responders":[{"type":"team","name":"missing"}]}`)
+	assert.Equal(t, http.StatusAccepted, code)
+	requestID, _ := result["requestId"].(string)
+	_, ok := s.Alert("webserver01/check-nginx")
+	assert.False(t, ok)
+
+	code, _ = do(t, s, http.MethodGet, "/v2/alerts/requests/"+requestID, "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, result = do(t, s, http.MethodGet, "/v2/alerts/requests/"+requestID, "")
+	assert.Equal(t, http.StatusOK, code)
+	status := result["data"].(map[string]interface{})
+	assert.Equal(t, false, status["isSuccess"])
+	assert.Equal(t, "Invalid responder", status["status"])
+}
+
+func TestDelay(t *testing.T) {
+	s := New()
+	defer s.Close()
+	s.Delay(50 * time.Millisecond)
+	start := time.Now()
+	code, _ := do(t, s, http.MethodGet, "/v2/heartbeats/heartbeat1/ping", "")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+}
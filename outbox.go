@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-go/types"
+)
+
+// outbox operations, one per request type saved in --outbox-dir
+const (
+	outboxCreate    = "create"
+	outboxClose     = "close"
+	outboxNote      = "note"
+	outboxDetails   = "details"
+	outboxHeartbeat = "heartbeat"
+)
+
+const (
+	// outboxLockFile is created with O_EXCL while a handler reads or writes --outbox-dir
+	outboxLockFile = ".lock"
+	// outboxLockStale is the age of a lock file left by a handler that did not finish
+	outboxLockStale = 2 * time.Minute
+)
+
+// outboxLockWait is how long a handler waits for another one to release the lock, tests can replace it
+var outboxLockWait = 5 * time.Second
+
+// outboxEnvelope represents one OpsGenie request saved in --outbox-dir
+type outboxEnvelope struct {
+	Operation string          `json:"operation"`
+	Alias     string          `json:"alias,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	Request   json.RawMessage `json:"request"`
+	file      string
+}
+
+// outboxEnabled func returns true if failed requests should be saved in --outbox-dir
+func outboxEnabled() bool {
+	return plugin.OutboxDir != "" && !plugin.DryRun
+}
+
+// lockOutbox func creates the lock file of an outbox dir and returns a func to remove it,
+// it waits up to outboxLockWait for another handler and stops waiting when ctx is done
+func lockOutbox(ctx context.Context, dir string) (func(), error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	lock := filepath.Join(dir, outboxLockFile)
+	deadline := time.Now().Add(outboxLockWait)
+	for {
+		file, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(file, "%d", os.Getpid())
+			file.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, statErr := os.Stat(lock); statErr == nil && time.Since(info.ModTime()) > outboxLockStale {
+			fmt.Printf("[WARN] Removing stale outbox lock %s \n", lock)
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("outbox %s is locked by another handler", dir)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("outbox %s is locked by another handler: %s", dir, ctx.Err())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// readOutbox func returns envelopes saved in an outbox dir from the oldest to the newest
+// files that cannot be parsed are removed
+func readOutbox(dir string) ([]*outboxEnvelope, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	envelopes := []*outboxEnvelope{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return envelopes, err
+		}
+		envelope := &outboxEnvelope{}
+		if err := json.Unmarshal(content, envelope); err != nil {
+			fmt.Printf("[WARN] Removing invalid outbox file %s: %s \n", file, err)
+			os.Remove(file)
+			continue
+		}
+		envelope.file = file
+		envelopes = append(envelopes, envelope)
+	}
+	return envelopes, nil
+}
+
+// writeOutbox func saves an envelope in a new file, names keep the order of envelopes
+func writeOutbox(dir string, envelope *outboxEnvelope) error {
+	content, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d-%06d-%s.json", envelope.CreatedAt.UnixNano(), rand.Intn(1000000), envelope.Operation)
+	temp := filepath.Join(dir, "."+name+".tmp")
+	if err := ioutil.WriteFile(temp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, filepath.Join(dir, name))
+}
+
+// enqueueOutbox func saves a request in the outbox of the ctx target
+// a close drops pending creates of the same alias and a create replaces an older create,
+// the oldest envelopes are dropped when --outbox-max-size is reached
+func enqueueOutbox(ctx context.Context, operation, alias string, request interface{}) error {
+	dir := currentTarget(ctx).outboxDir
+	unlock, err := lockOutbox(ctx, dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	envelopes, err := readOutbox(dir)
+	if err != nil {
+		return err
+	}
+	if alias != "" && (operation == outboxCreate || operation == outboxClose) {
+		envelopes = dropOutboxCreates(envelopes, alias)
+	}
+	for plugin.OutboxMaxSize > 0 && len(envelopes) >= plugin.OutboxMaxSize {
+		fmt.Printf("[WARN] Outbox is full, dropping %s request %s \n", envelopes[0].Operation, envelopes[0].Alias)
+		os.Remove(envelopes[0].file)
+		envelopes = envelopes[1:]
+	}
+	content, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return writeOutbox(dir, &outboxEnvelope{
+		Operation: operation,
+		Alias:     alias,
+		CreatedAt: now(),
+		Request:   content,
+	})
+}
+
+// dropOutboxCreates func removes pending creates of an alias and returns the envelopes left
+func dropOutboxCreates(envelopes []*outboxEnvelope, alias string) []*outboxEnvelope {
+	left := []*outboxEnvelope{}
+	for _, envelope := range envelopes {
+		if envelope.Operation == outboxCreate && envelope.Alias == alias {
+			fmt.Printf("Dropping pending create of %s from outbox \n", alias)
+			os.Remove(envelope.file)
+			continue
+		}
+		left = append(left, envelope)
+	}
+	return left
+}
+
+// forgetOutboxCreates func drops pending creates of an alias after it was closed,
+// so a stale create does not reopen it
+func forgetOutboxCreates(ctx context.Context, alias string) error {
+	if !outboxEnabled() {
+		return nil
+	}
+	dir := currentTarget(ctx).outboxDir
+	unlock, err := lockOutbox(ctx, dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	envelopes, err := readOutbox(dir)
+	if err != nil {
+		return err
+	}
+	dropOutboxCreates(envelopes, alias)
+	return nil
+}
+
+// spoolOnFailure func saves a request in the outbox of the ctx target when err is retryable
+// and returns err saying if the request was saved
+func spoolOnFailure(ctx context.Context, err error, operation, alias string, request interface{}) error {
+	if err == nil || !outboxEnabled() || !isRetryable(err) {
+		return err
+	}
+	if spoolErr := enqueueOutbox(ctx, operation, alias, request); spoolErr != nil {
+		return fmt.Errorf("%w (not saved in outbox: %s)", err, spoolErr)
+	}
+	return fmt.Errorf("%w (request saved in outbox %s)", err, currentTarget(ctx).outboxDir)
+}
+
+// replayOutbox func sends envelopes saved in the outbox of the ctx target in order and returns how many are left.
+// Expired envelopes and envelopes that succeed or fail with a permanent error are removed,
+// replay stops at the first retryable error to keep the order
+func replayOutbox(ctx context.Context, alertClient AlertAPI) (int, error) {
+	dir := currentTarget(ctx).outboxDir
+	unlock, err := lockOutbox(ctx, dir)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	envelopes, err := readOutbox(dir)
+	if err != nil {
+		return 0, err
+	}
+	maxAge, err := time.ParseDuration(plugin.OutboxMaxAge)
+	if err != nil {
+		return len(envelopes), fmt.Errorf("invalid --outbox-max-age: %s", err)
+	}
+	var heartbeatClient HeartbeatAPI
+	for i, envelope := range envelopes {
+		if now().Sub(envelope.CreatedAt) > maxAge {
+			fmt.Printf("[WARN] Dropping %s request %s from outbox: older than %s \n", envelope.Operation, envelope.Alias, plugin.OutboxMaxAge)
+			os.Remove(envelope.file)
+			continue
+		}
+		if envelope.Operation == outboxHeartbeat && heartbeatClient == nil {
+			heartbeatClient, err = targetHeartbeatClient(ctx)
+			if err != nil {
+				return len(envelopes) - i, err
+			}
+		}
+		err := callWithTimeout(ctx, func(ctx context.Context) error {
+			return sendEnvelope(ctx, alertClient, heartbeatClient, envelope)
+		})
+		if isRetryable(err) {
+			return len(envelopes) - i, fmt.Errorf("outbox replay stopped at %s request %s: %w", envelope.Operation, envelope.Alias, err)
+		}
+		if err != nil {
+			fmt.Printf("[ERROR] Dropping %s request %s from outbox: %s \n", envelope.Operation, envelope.Alias, err)
+		} else {
+			fmt.Printf("Replayed %s request %s from outbox \n", envelope.Operation, envelope.Alias)
+		}
+		os.Remove(envelope.file)
+	}
+	return 0, nil
+}
+
+// sendEnvelope func sends the request saved in an envelope
+func sendEnvelope(ctx context.Context, alertClient AlertAPI, heartbeatClient HeartbeatAPI, envelope *outboxEnvelope) error {
+	switch envelope.Operation {
+	case outboxCreate:
+		request := &alert.CreateAlertRequest{}
+		if err := json.Unmarshal(envelope.Request, request); err != nil {
+			return err
+		}
+		_, err := alertClient.Create(ctx, request)
+		return err
+	case outboxClose:
+		request := &alert.CloseAlertRequest{}
+		if err := json.Unmarshal(envelope.Request, request); err != nil {
+			return err
+		}
+		_, err := alertClient.Close(ctx, request)
+		return err
+	case outboxNote:
+		request := &alert.AddNoteRequest{}
+		if err := json.Unmarshal(envelope.Request, request); err != nil {
+			return err
+		}
+		_, err := alertClient.AddNote(ctx, request)
+		return err
+	case outboxDetails:
+		request := &alert.AddDetailsRequest{}
+		if err := json.Unmarshal(envelope.Request, request); err != nil {
+			return err
+		}
+		_, err := alertClient.AddDetails(ctx, request)
+		return err
+	case outboxHeartbeat:
+		var name string
+		if err := json.Unmarshal(envelope.Request, &name); err != nil {
+			return err
+		}
+		_, err := heartbeatClient.Ping(ctx, name)
+		return err
+	default:
+		return fmt.Errorf("unknown outbox operation %q", envelope.Operation)
+	}
+}
+
+// checkFlushArgs func validates the flush subcommand configuration
+func checkFlushArgs(_ *types.Event) (int, error) {
+	if plugin.OutboxDir == "" {
+		return sensu.CheckStateUnknown, fmt.Errorf("--outbox-dir is empty")
+	}
+	if len(plugin.AuthToken) == 0 && plugin.TenantMap == "" && plugin.Targets == "" {
+		return sensu.CheckStateUnknown, fmt.Errorf("authentication token is empty")
+	}
+	if _, err := parseTenantMap(plugin.TenantMap); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	if err := checkTargets(); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	if plugin.TenantMap != "" && plugin.Targets != "" {
+		return sensu.CheckStateUnknown, fmt.Errorf("Cannot use both options: --tenant-map and --targets ")
+	}
+	if _, err := time.ParseDuration(plugin.OutboxMaxAge); err != nil {
+		return sensu.CheckStateUnknown, fmt.Errorf("invalid --outbox-max-age: %s", err)
+	}
+	if err := checkTimeouts(); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	if err := checkBackend(); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	if _, err := httpClient(); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	return sensu.CheckStateOK, nil
+}
+
+// executeFlush func replays --outbox-dir and the outbox of each --tenant-map tenant,
+// it returns warning if requests are left
+func executeFlush(_ *types.Event) (int, error) {
+	ctx, cancel := handlerContext()
+	defer cancel()
+	tenants, err := parseTenantMap(plugin.TenantMap)
+	if err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	left := 0
+	if len(plugin.AuthToken) != 0 || plugin.Targets != "" {
+		left, err = flushOutbox(ctx)
+		if err != nil {
+			return sensu.CheckStateUnknown, err
+		}
+	}
+	global := plugin
+	for _, t := range sortedTenants(tenants) {
+		if _, err := os.Stat(filepath.Join(plugin.OutboxDir, t.name)); err != nil {
+			continue
+		}
+		if err := useTenant(t, nil); err != nil {
+			fmt.Printf("[WARN] %s \n", err)
+			left++
+			continue
+		}
+		tenantLeft, err := flushOutbox(ctx)
+		plugin = global
+		if err != nil {
+			return sensu.CheckStateUnknown, err
+		}
+		left += tenantLeft
+	}
+	if left != 0 {
+		return sensu.CheckStateWarning, nil
+	}
+	return sensu.CheckStateOK, nil
+}
+
+// flushOutbox func replays --outbox-dir with --auth, or the outbox of each --targets target,
+// and returns how many requests are left
+func flushOutbox(ctx context.Context) (int, error) {
+	targets, err := parseTargets(plugin.Targets)
+	if err != nil {
+		return 0, err
+	}
+	if len(targets) == 0 {
+		return flushTarget(ctx)
+	}
+	left := 0
+	for _, t := range targets {
+		if _, err := os.Stat(filepath.Join(plugin.OutboxDir, t.name)); err != nil {
+			continue
+		}
+		t, err := resolveTarget(t)
+		if err != nil {
+			fmt.Printf("[WARN] Target %s: %s \n", t.name, err)
+			left++
+			continue
+		}
+		targetLeft, err := flushTarget(withTarget(ctx, t))
+		if err != nil {
+			return left, err
+		}
+		left += targetLeft
+	}
+	return left, nil
+}
+
+// flushTarget func replays the outbox of the ctx target and returns how many requests are left
+func flushTarget(ctx context.Context) (int, error) {
+	dir := currentTarget(ctx).outboxDir
+	alertClient, err := targetAlertClient(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create opsgenie client: %s", err)
+	}
+	left, err := replayOutbox(ctx, alertClient)
+	if err != nil {
+		fmt.Printf("[WARN] %s \n", err)
+	}
+	if left != 0 {
+		fmt.Printf("%d requests left in outbox %s \n", left, dir)
+	} else {
+		fmt.Printf("Outbox %s is empty \n", dir)
+	}
+	return left, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/opsgenie/opsgenie-go-sdk-v2/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// useOutbox enables --outbox-dir in a temporary directory until the test ends
+func useOutbox(t *testing.T) string {
+	plugin.OutboxDir = t.TempDir()
+	plugin.OutboxMaxAge = "24h"
+	plugin.OutboxMaxSize = 1000
+	t.Cleanup(func() {
+		plugin.OutboxDir = ""
+		plugin.OutboxMaxAge = ""
+		plugin.OutboxMaxSize = 0
+	})
+	return plugin.OutboxDir
+}
+
+func outboxOperations(t *testing.T) []string {
+	envelopes, err := readOutbox(plugin.OutboxDir)
+	assert.NoError(t, err)
+	operations := []string{}
+	for _, envelope := range envelopes {
+		operations = append(operations, envelope.Operation+" "+envelope.Alias)
+	}
+	return operations
+}
+
+func TestEnqueueOutbox(t *testing.T) {
+	useOutbox(t)
+	assert.NoError(t, enqueueOutbox(context.Background(), outboxCreate, "entity1/check1", &alert.CreateAlertRequest{Message: "first", Alias: "entity1/check1"}))
+	assert.NoError(t, enqueueOutbox(context.Background(), outboxCreate, "entity2/check1", &alert.CreateAlertRequest{Message: "other", Alias: "entity2/check1"}))
+	assert.NoError(t, enqueueOutbox(context.Background(), outboxCreate, "entity1/check1", &alert.CreateAlertRequest{Message: "second", Alias: "entity1/check1"}))
+	assert.Equal(t, []string{"create entity2/check1", "create entity1/check1"}, outboxOperations(t))
+
+	// a close drops pending creates of the same alias
+	assert.NoError(t, enqueueOutbox(context.Background(), outboxClose, "entity1/check1", &alert.CloseAlertRequest{IdentifierType: alert.ALIAS, IdentifierValue: "entity1/check1"}))
+	assert.Equal(t, []string{"create entity2/check1", "close entity1/check1"}, outboxOperations(t))
+
+	// the oldest requests are dropped when the outbox is full
+	plugin.OutboxMaxSize = 2
+	assert.NoError(t, enqueueOutbox(context.Background(), outboxHeartbeat, "", "heartbeat1"))
+	assert.Equal(t, []string{"close entity1/check1", "heartbeat "}, outboxOperations(t))
+
+	assert.NoError(t, forgetOutboxCreates(context.Background(), "entity1/check1"))
+	assert.Len(t, outboxOperations(t), 2)
+}
+
+func TestReplayOutbox(t *testing.T) {
+	useOutbox(t)
+	alertAPI, heartbeatAPI := useFakeClients(t, nil)
+	assert.NoError(t, enqueueOutbox(context.Background(), outboxCreate, "entity1/check1", &alert.CreateAlertRequest{Message: "entity1/check1", Alias: "entity1/check1", Priority: alert.P2}))
+	assert.NoError(t, enqueueOutbox(context.Background(), outboxNote, "", &alert.AddNoteRequest{IdentifierValue: "alert-id", Note: "note"}))
+	assert.NoError(t, enqueueOutbox(context.Background(), outboxDetails, "", &alert.AddDetailsRequest{IdentifierValue: "alert-id", Details: map[string]string{"status": "2"}}))
+	assert.NoError(t, enqueueOutbox(context.Background(), outboxHeartbeat, "", "heartbeat1"))
+
+	// replay stops at a retryable error and keeps the order
+	alertAPI.failures = []error{&client.ApiError{StatusCode: 503}}
+	left, err := replayOutbox(context.Background(), alertAPI)
+	assert.Error(t, err)
+	assert.Equal(t, 4, left)
+	assert.Len(t, outboxOperations(t), 4)
+
+	// permanent errors are dropped
+	alertAPI.calls = nil
+	alertAPI.failures = []error{&client.ApiError{StatusCode: 422}}
+	left, err = replayOutbox(context.Background(), alertAPI)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, left)
+	assert.Equal(t, []string{"Create entity1/check1 P2", "AddNote alert-id", "AddDetails alert-id"}, alertAPI.calls)
+	assert.Equal(t, []string{"heartbeat1"}, heartbeatAPI.pings)
+	assert.Empty(t, outboxOperations(t))
+}
+
+func TestReplayOutboxMaxAge(t *testing.T) {
+	useOutbox(t)
+	alertAPI, _ := useFakeClients(t, nil)
+	now = func() time.Time { return time.Now().Add(-48 * time.Hour) }
+	defer func() { now = time.Now }()
+	assert.NoError(t, enqueueOutbox(context.Background(), outboxCreate, "entity1/check1", &alert.CreateAlertRequest{Message: "old", Alias: "entity1/check1"}))
+	now = time.Now
+	left, err := replayOutbox(context.Background(), alertAPI)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, left)
+	assert.Empty(t, alertAPI.calls)
+	assert.Empty(t, outboxOperations(t))
+}
+
+func TestLockOutbox(t *testing.T) {
+	dir := useOutbox(t)
+	outboxLockWait = 100 * time.Millisecond
+	defer func() { outboxLockWait = 5 * time.Second }()
+	unlock, err := lockOutbox(context.Background(), plugin.OutboxDir)
+	assert.NoError(t, err)
+	_, err = lockOutbox(context.Background(), plugin.OutboxDir)
+	assert.Error(t, err)
+
+	// waiting stops when the handler deadline is reached
+	outboxLockWait = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = lockOutbox(ctx, plugin.OutboxDir)
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	unlock()
+
+	// a lock left by a handler that did not finish is removed
+	lock := filepath.Join(dir, outboxLockFile)
+	assert.NoError(t, ioutil.WriteFile(lock, []byte("1"), 0600))
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(lock, old, old))
+	unlock, err = lockOutbox(context.Background(), plugin.OutboxDir)
+	assert.NoError(t, err)
+	unlock()
+	_, err = os.Stat(lock)
+	assert.True(t, os.IsNotExist(err))
+}
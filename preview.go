@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/opsgenie/opsgenie-go-sdk-v2/client"
+	"github.com/opsgenie/opsgenie-go-sdk-v2/heartbeat"
+	"github.com/sensu/sensu-go/types"
+)
+
+// previewAlertID is the alert ID returned by previewClient when the handler looks for an alert
+const previewAlertID = "dry-run-alert-id"
+
+// previewOutput is where previewClient prints requests, tests can replace it
+var previewOutput io.Writer = os.Stdout
+
+// previewRequest represents one request rendered by previewClient
+type previewRequest struct {
+	Operation  string      `json:"operation"`
+	Identifier string      `json:"identifier,omitempty"`
+	Request    interface{} `json:"request,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// previewClient implements AlertAPI and HeartbeatAPI saving requests instead of sending them
+type previewClient struct {
+	Branch   string           `json:"branch"`
+	Requests []previewRequest `json:"requests"`
+	event    *types.Event
+	output   io.Writer
+}
+
+// newPreviewClient func returns a previewClient for --dry-run and preview subcommand
+func newPreviewClient(event *types.Event, branch string) *previewClient {
+	return &previewClient{
+		Branch:   branch,
+		Requests: []previewRequest{},
+		event:    event,
+		output:   previewOutput,
+	}
+}
+
+// record func saves a request and the validation error that OpsGenie SDK would return
+func (p *previewClient) record(operation, identifier string, request client.ApiRequest) (*alert.AsyncAlertResult, error) {
+	rendered := previewRequest{Operation: operation, Identifier: identifier, Request: request}
+	if err := request.Validate(); err != nil {
+		rendered.Error = err.Error()
+	}
+	p.Requests = append(p.Requests, rendered)
+	return &alert.AsyncAlertResult{ResultMetadata: client.ResultMetadata{RequestId: "dry-run"}}, nil
+}
+
+// logToStderr func sends handler log lines to stderr while previewing, so the JSON written to previewOutput
+// is the only thing on stdout and can be piped to jq. It returns a func restoring stdout
+func logToStderr() func() {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	return func() { os.Stdout = stdout }
+}
+
+// print func writes branch and requests as JSON
+func (p *previewClient) print() {
+	output, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		fmt.Printf("[ERROR] Cannot render preview: %s \n", err)
+		return
+	}
+	fmt.Fprintf(p.output, "%s\n", output)
+}
+
+func (p *previewClient) Create(ctx context.Context, req *alert.CreateAlertRequest) (*alert.AsyncAlertResult, error) {
+	return p.record("create", req.Alias, req)
+}
+
+// Get returns an open alert with the current check status, so every request of the branch is rendered
+func (p *previewClient) Get(ctx context.Context, req *alert.GetAlertRequest) (*alert.GetAlertResult, error) {
+	p.Requests = append(p.Requests, previewRequest{Operation: "get", Identifier: req.IdentifierValue})
+	return &alert.GetAlertResult{
+		Id:      previewAlertID,
+		Alias:   req.IdentifierValue,
+		Status:  "open",
+		Details: map[string]string{"status": fmt.Sprintf("%d", p.event.Check.Status)},
+	}, nil
+}
+
+func (p *previewClient) Close(ctx context.Context, req *alert.CloseAlertRequest) (*alert.AsyncAlertResult, error) {
+	return p.record("close", req.IdentifierValue, req)
+}
+
+func (p *previewClient) AddNote(ctx context.Context, req *alert.AddNoteRequest) (*alert.AsyncAlertResult, error) {
+	return p.record("addNote", req.IdentifierValue, req)
+}
+
+func (p *previewClient) Acknowledge(ctx context.Context, req *alert.AcknowledgeAlertRequest) (*alert.AsyncAlertResult, error) {
+	return p.record("acknowledge", req.IdentifierValue, req)
+}
+
+func (p *previewClient) Snooze(ctx context.Context, req *alert.SnoozeAlertRequest) (*alert.AsyncAlertResult, error) {
+	return p.record("snooze", req.IdentifierValue, req)
+}
+
+func (p *previewClient) AddDetails(ctx context.Context, req *alert.AddDetailsRequest) (*alert.AsyncAlertResult, error) {
+	return p.record("addDetails", req.IdentifierValue, req)
+}
+
+func (p *previewClient) AddResponder(ctx context.Context, req *alert.AddResponderRequest) (*alert.AsyncAlertResult, error) {
+	return p.record("addResponder", req.IdentifierValue, req)
+}
+
+func (p *previewClient) AddTags(ctx context.Context, req *alert.AddTagsRequest) (*alert.AsyncAlertResult, error) {
+	return p.record("addTags", req.IdentifierValue, req)
+}
+
+func (p *previewClient) UpdatePriority(ctx context.Context, req *alert.UpdatePriorityRequest) (*alert.AsyncAlertResult, error) {
+	return p.record("updatePriority", req.IdentifierValue, req)
+}
+
+func (p *previewClient) UpdateMessage(ctx context.Context, req *alert.UpdateMessageRequest) (*alert.AsyncAlertResult, error) {
+	return p.record("updateMessage", req.IdentifierValue, req)
+}
+
+func (p *previewClient) UpdateDescription(ctx context.Context, req *alert.UpdateDescriptionRequest) (*alert.AsyncAlertResult, error) {
+	return p.record("updateDescription", req.IdentifierValue, req)
+}
+
+// GetRequestStatus returns a processed request, so --verify can be previewed
+func (p *previewClient) GetRequestStatus(ctx context.Context, req *alert.GetRequestStatusRequest) (*alert.RequestStatusResult, error) {
+	p.Requests = append(p.Requests, previewRequest{Operation: "getRequestStatus", Identifier: req.RequestId})
+	return &alert.RequestStatusResult{IsSuccess: true, Status: "dry-run", AlertID: previewAlertID}, nil
+}
+
+func (p *previewClient) Ping(ctx context.Context, heartbeatName string) (*heartbeat.PingResult, error) {
+	p.Requests = append(p.Requests, previewRequest{Operation: "ping", Identifier: heartbeatName})
+	return &heartbeat.PingResult{ResultMetadata: client.ResultMetadata{RequestId: "dry-run"}, Message: "dry-run"}, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerBranch(t *testing.T) {
+	defer func() {
+		plugin.RemediationEvents = false
+		plugin.HeartbeatEvents = false
+		plugin.HeartbeatMap = ""
+	}()
+	testCases := []struct {
+		status      uint32
+		remediation bool
+		heartbeat   bool
+		expected    string
+	}{
+		{2, false, false, branchCreate},
+		{0, false, false, branchClose},
+		{0, true, false, branchRemediationUpdate},
+		{1, true, false, branchRemediationDrop},
+		{0, false, true, branchHeartbeatPing},
+		{1, false, true, branchHeartbeatDrop},
+	}
+	for _, tc := range testCases {
+		plugin.RemediationEvents = tc.remediation
+		plugin.HeartbeatEvents = tc.heartbeat
+		plugin.HeartbeatMap = "entity1/check1=heartbeat1"
+		event := types.FixtureEvent("entity1", "check1")
+		event.Check.Status = tc.status
+		assert.Equal(t, tc.expected, handlerBranch(event))
+	}
+}
+
+func TestExecuteHandlerDryRun(t *testing.T) {
+	plugin.DryRun = true
+	plugin.AliasTemplate = "{{.Entity.Name}}/{{.Check.Name}}"
+	plugin.MessageTemplate = "{{.Entity.Name}}/{{.Check.Name}}"
+	plugin.DescriptionTemplate = "{{.Check.Output}}"
+	plugin.Priority = "P3"
+	var output bytes.Buffer
+	previewOutput = &output
+	authToken := plugin.AuthToken
+	plugin.AuthToken = ""
+	defer func() {
+		plugin.DryRun = false
+		plugin.AuthToken = authToken
+		previewOutput = os.Stdout
+	}()
+	// no opsgenie client should be created
+	alertAPI, _ := useFakeClients(t, nil)
+
+	event := types.FixtureEvent("entity1", "check1")
+	event.Check.Status = 2
+	event.Check.Output = "critical"
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, executeHandler(event))
+	rendered := struct {
+		Branch   string
+		Requests []struct {
+			Operation  string
+			Identifier string
+			Request    map[string]interface{}
+			Error      string
+		}
+	}{}
+	assert.NoError(t, json.Unmarshal(output.Bytes(), &rendered))
+	assert.Equal(t, branchCreate, rendered.Branch)
+	assert.Len(t, rendered.Requests, 1)
+	assert.Equal(t, "create", rendered.Requests[0].Operation)
+	assert.Equal(t, "entity1/check1", rendered.Requests[0].Request["alias"])
+	assert.Equal(t, "critical", rendered.Requests[0].Request["description"])
+	assert.Equal(t, "P3", rendered.Requests[0].Request["priority"])
+	assert.Empty(t, rendered.Requests[0].Error)
+
+	output.Reset()
+	event.Check.Status = 0
+	assert.NoError(t, executeHandler(event))
+	assert.NoError(t, json.Unmarshal(output.Bytes(), &rendered))
+	assert.Equal(t, branchClose, rendered.Branch)
+	assert.Len(t, rendered.Requests, 2)
+	assert.Equal(t, "get", rendered.Requests[0].Operation)
+	assert.Equal(t, "close", rendered.Requests[1].Operation)
+	assert.Equal(t, previewAlertID, rendered.Requests[1].Identifier)
+	assert.Contains(t, rendered.Requests[1].Request["note"], "Closed Automatically")
+
+	assert.Empty(t, alertAPI.calls)
+}
+
+func TestDryRunLogsToStderr(t *testing.T) {
+	plugin.DryRun = true
+	plugin.AliasTemplate = "{{.Entity.Name}}/{{.Check.Name}}"
+	plugin.MessageTemplate = "{{.Entity.Name}}/{{.Check.Name}}"
+	var output bytes.Buffer
+	previewOutput = &output
+	stdout, stderr := os.Stdout, os.Stderr
+	logs, err := ioutil.TempFile(t.TempDir(), "stderr")
+	assert.NoError(t, err)
+	os.Stderr = logs
+	defer func() {
+		plugin.DryRun = false
+		previewOutput = os.Stdout
+		os.Stderr = stderr
+		logs.Close()
+	}()
+	useFakeClients(t, nil)
+
+	event := types.FixtureEvent("entity1", "check1")
+	event.Check.Status = 2
+	assert.NoError(t, executeHandler(event))
+	assert.Equal(t, stdout, os.Stdout)
+	content, err := ioutil.ReadFile(logs.Name())
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "Create request ID: dry-run")
+	assert.True(t, json.Valid(output.Bytes()))
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu-community/sensu-plugin-sdk/templates"
+	"github.com/sensu/sensu-go/types"
+)
+
+// respondersAnnotation is the check or entity annotation with responders added to --responder
+const respondersAnnotation = "opsgenie_responders"
+
+// parseResponder func parses a responder in type:value, type:id:value or type:name:value format,
+// like user:alice@example.com, team:id:4513b7ea-3b91-438f-b7e4-e3e54af9147c or schedule:name:primary.
+// Type is user, team, schedule or escalation, users name is their username
+func parseResponder(s string) (alert.Responder, error) {
+	s = strings.TrimSpace(s)
+	fields := strings.SplitN(s, ":", 3)
+	if len(fields) < 2 {
+		return alert.Responder{}, fmt.Errorf("responder %q wrong format: type:[id|name:]value", s)
+	}
+	responderType := alert.ResponderType(strings.ToLower(fields[0]))
+	switch responderType {
+	case alert.UserResponder, alert.TeamResponder, alert.ScheduleResponder, alert.EscalationResponder:
+	default:
+		return alert.Responder{}, fmt.Errorf("responder %q invalid type %q: use user, team, schedule or escalation", s, fields[0])
+	}
+	key, value := "name", fields[1]
+	if len(fields) == 3 {
+		key, value = strings.ToLower(fields[1]), fields[2]
+	}
+	if value == "" {
+		return alert.Responder{}, fmt.Errorf("responder %q has no value", s)
+	}
+	responder := alert.Responder{Type: responderType}
+	switch key {
+	case "id":
+		responder.Id = value
+	case "name", "username":
+		if responderType == alert.UserResponder {
+			responder.Username = value
+		} else {
+			responder.Name = value
+		}
+	default:
+		return alert.Responder{}, fmt.Errorf("responder %q invalid key %q: use id or name", s, fields[1])
+	}
+	return responder, nil
+}
+
+// parseResponders func parses a comma separated list of responders
+func parseResponders(s string) ([]alert.Responder, error) {
+	responders := []alert.Responder{}
+	for _, v := range splitStringInSlice(s) {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		responder, err := parseResponder(v)
+		if err != nil {
+			return responders, err
+		}
+		responders = append(responders, responder)
+	}
+	return responders, nil
+}
+
+// parseVisibility func parses visibility entries, plain names are teams and
+// entries with type use the responder format, only teams and users are allowed
+func parseVisibility(entries []string) ([]alert.Responder, error) {
+	visibility := []alert.Responder{}
+	for _, v := range entries {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, ":") {
+			visibility = append(visibility, alert.Responder{Type: alert.TeamResponder, Name: v})
+			continue
+		}
+		responder, err := parseResponder(v)
+		if err != nil {
+			return visibility, err
+		}
+		if responder.Type != alert.TeamResponder && responder.Type != alert.UserResponder {
+			return visibility, fmt.Errorf("visibility %q invalid type %q: use team or user", v, responder.Type)
+		}
+		visibility = append(visibility, responder)
+	}
+	return visibility, nil
+}
+
+// flagResponders func returns --responder, checkArgs rejects invalid entries
+func flagResponders() []alert.Responder {
+	responders, err := parseResponders(plugin.Responders)
+	if err != nil {
+		fmt.Printf("[WARN] Ignoring --responder: %s \n", err)
+		return []alert.Responder{}
+	}
+	return responders
+}
+
+// checkResponders func validates --responder and --visibility-teams, and the templates of responders flags
+func checkResponders() error {
+	if _, err := parseResponders(plugin.Responders); err != nil {
+		return fmt.Errorf("invalid --responder: %s", err)
+	}
+	if _, err := parseVisibility(splitStringInSlice(plugin.VisibilityTeams)); err != nil {
+		return fmt.Errorf("invalid --visibility-teams: %s", err)
+	}
+	flags := []struct {
+		name  string
+		value string
+	}{
+		{"--team", plugin.Team},
+		{"--escalation-team", plugin.EscalationTeam},
+		{"--schedule-team", plugin.ScheduleTeam},
+		{"--visibility-teams", plugin.VisibilityTeams},
+		{"--responder", plugin.Responders},
+	}
+	for _, flag := range flags {
+		for _, v := range splitStringInSlice(flag.value) {
+			if !strings.Contains(v, "{{") {
+				continue
+			}
+			if _, err := template.New(flag.name).Parse(v); err != nil {
+				return fmt.Errorf("invalid template %q in %s: %s", v, flag.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// renderResponders func evaluates responder names, usernames and IDs with templates against the event,
+// like {{.Entity.Labels.owner_team}}. A result with commas is split in several responders, empty results,
+// missing labels and template errors are dropped, and duplicated responders are removed
+func renderResponders(event *types.Event, responders []alert.Responder) []alert.Responder {
+	local := []alert.Responder{}
+	seen := make(map[alert.Responder]bool)
+	add := func(responder alert.Responder) {
+		if !seen[responder] {
+			seen[responder] = true
+			local = append(local, responder)
+		}
+	}
+	for _, responder := range responders {
+		field := &responder.Name
+		switch {
+		case responder.Id != "":
+			field = &responder.Id
+		case responder.Username != "":
+			field = &responder.Username
+		}
+		if !strings.Contains(*field, "{{") {
+			add(responder)
+			continue
+		}
+		rendered, err := templates.EvalTemplate("responder", *field, event)
+		if err != nil {
+			fmt.Printf("[WARN] Ignoring %s responder %q: %s \n", responder.Type, *field, err)
+			continue
+		}
+		for _, v := range splitStringInSlice(strings.Replace(rendered, "<no value>", "", -1)) {
+			if v = strings.TrimSpace(v); v != "" {
+				*field = v
+				add(responder)
+			}
+		}
+	}
+	return local
+}
+
+// eventResponders func renders responders templates and uses --fallback-team when no responder is left
+func eventResponders(event *types.Event, responders []alert.Responder) []alert.Responder {
+	local := renderResponders(event, responders)
+	if len(local) == 0 && plugin.FallbackTeam != "" {
+		fmt.Printf("No responders resolved, using fallback team %s \n", plugin.FallbackTeam)
+		return responderList(alert.TeamResponder, splitStringInSlice(plugin.FallbackTeam))
+	}
+	return local
+}
+
+// annotationResponders func returns responders of the opsgenie_responders and keyspace responders
+// annotations of check and entity, invalid annotations are logged and ignored
+func annotationResponders(event *types.Event) []alert.Responder {
+	responders := []alert.Responder{}
+	keys := []string{path.Join(plugin.Keyspace, "responders"), respondersAnnotation}
+	for _, source := range []struct {
+		kind        string
+		annotations map[string]string
+	}{
+		{"check", checkAnnotations(event)},
+		{"entity", entityAnnotations(event)},
+	} {
+		for _, key := range keys {
+			value, ok := source.annotations[key]
+			if !ok || value == "" {
+				continue
+			}
+			parsed, err := parseResponders(value)
+			if err != nil {
+				fmt.Printf("[WARN] Ignoring %s annotation %s: %s \n", source.kind, key, err)
+				continue
+			}
+			responders = append(responders, parsed...)
+		}
+	}
+	return responders
+}
+
+// checkAnnotations func returns check annotations or nil
+func checkAnnotations(event *types.Event) map[string]string {
+	if event.Check == nil {
+		return nil
+	}
+	return event.Check.Annotations
+}
+
+// entityAnnotations func returns entity annotations or nil
+func entityAnnotations(event *types.Event) map[string]string {
+	if event.Entity == nil {
+		return nil
+	}
+	return event.Entity.Annotations
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResponders(t *testing.T) {
+	responders, err := parseResponders("user:alice@example.com, team:id:4513b7ea-3b91-438f-b7e4-e3e54af9147c,Schedule:name:primary,escalation:sre-esc,user:id:8a2b")
+	assert.NoError(t, err)
+	assert.Equal(t, []alert.Responder{
+		{Type: alert.UserResponder, Username: "alice@example.com"},
+		{Type: alert.TeamResponder, Id: "4513b7ea-3b91-438f-b7e4-e3e54af9147c"},
+		{Type: alert.ScheduleResponder, Name: "primary"},
+		{Type: alert.EscalationResponder, Name: "sre-esc"},
+		{Type: alert.UserResponder, Id: "8a2b"},
+	}, responders)
+
+	invalid := []string{
+		"sre",
+		"group:sre",
+		"team:",
+		"team:id:",
+		"team:key:sre",
+		"user:alice@example.com,team",
+	}
+	for _, v := range invalid {
+		_, err := parseResponders(v)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestParseVisibility(t *testing.T) {
+	visibility, err := parseVisibility([]string{"sre", "user:alice@example.com", "team:id:4513b7ea", ""})
+	assert.NoError(t, err)
+	assert.Equal(t, []alert.Responder{
+		{Type: alert.TeamResponder, Name: "sre"},
+		{Type: alert.UserResponder, Username: "alice@example.com"},
+		{Type: alert.TeamResponder, Id: "4513b7ea"},
+	}, visibility)
+
+	for _, v := range []string{"schedule:primary", "user:"} {
+		_, err := parseVisibility([]string{v})
+		assert.Error(t, err, v)
+	}
+}
+
+func TestCheckResponders(t *testing.T) {
+	defer func() {
+		plugin.Responders = ""
+		plugin.VisibilityTeams = ""
+	}()
+	plugin.Responders = "user:alice@example.com"
+	plugin.VisibilityTeams = "sre,user:bob@example.com"
+	assert.NoError(t, checkResponders())
+	plugin.Responders = "alice@example.com"
+	assert.Error(t, checkResponders())
+	plugin.Responders = ""
+	plugin.VisibilityTeams = "escalation:sre-esc"
+	assert.Error(t, checkResponders())
+}
+
+func TestAnnotationResponders(t *testing.T) {
+	event := types.FixtureEvent("entity1", "check1")
+	event.Check.Annotations = map[string]string{
+		respondersAnnotation: "user:alice@example.com",
+		"sensu.io/plugins/sensu-opsgenie-handler/config/responders": "schedule:primary",
+	}
+	event.Entity.Annotations = map[string]string{
+		respondersAnnotation: "team:id:4513b7ea",
+	}
+	assert.Equal(t, []alert.Responder{
+		{Type: alert.ScheduleResponder, Name: "primary"},
+		{Type: alert.UserResponder, Username: "alice@example.com"},
+		{Type: alert.TeamResponder, Id: "4513b7ea"},
+	}, annotationResponders(event))
+
+	// invalid annotations are ignored
+	event.Check.Annotations = map[string]string{respondersAnnotation: "oncall"}
+	assert.Equal(t, []alert.Responder{{Type: alert.TeamResponder, Id: "4513b7ea"}}, annotationResponders(event))
+}
+
+func TestRespondersEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	plugin.Team = "sre"
+	plugin.Responders = "user:alice@example.com,escalation:id:9c1f"
+	plugin.VisibilityTeams = "managers,user:bob@example.com"
+	defer func() {
+		plugin.Team = ""
+		plugin.Responders = ""
+		plugin.VisibilityTeams = ""
+	}()
+	event := types.FixtureEvent("entity1", "check1")
+	event.Check.Status = 2
+	event.Check.Annotations = map[string]string{respondersAnnotation: "schedule:name:primary"}
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, executeHandler(event))
+	created, ok := server.Alert("entity1/check1")
+	assert.True(t, ok)
+	assert.Len(t, created.Responders, 4)
+	assert.Equal(t, "sre", created.Responders[0].Name)
+	assert.Equal(t, "alice@example.com", created.Responders[1].Username)
+	assert.Equal(t, "9c1f", created.Responders[2].ID)
+	assert.Equal(t, "schedule", created.Responders[3].Type)
+	assert.Equal(t, "bob@example.com", created.VisibleTo[1].Username)
+}
+
+func TestRenderResponders(t *testing.T) {
+	event := types.FixtureEvent("entity1", "check1")
+	event.Entity.Labels = map[string]string{"owner_team": "payments", "oncall": "payments-oncall,ops-oncall"}
+	event.Check.Labels = map[string]string{"owner": "alice@example.com"}
+	responders := []alert.Responder{
+		{Type: alert.TeamResponder, Name: "{{.Entity.Labels.owner_team}}"},
+		{Type: alert.ScheduleResponder, Name: "{{.Entity.Labels.oncall}}"},
+		{Type: alert.UserResponder, Username: "{{.Check.Labels.owner}}"},
+		{Type: alert.EscalationResponder, Name: "{{.Entity.Labels.escalation}}"},
+		{Type: alert.TeamResponder, Name: "{{.Entity.Missing}}"},
+		{Type: alert.TeamResponder, Name: "payments"},
+	}
+	assert.Equal(t, []alert.Responder{
+		{Type: alert.TeamResponder, Name: "payments"},
+		{Type: alert.ScheduleResponder, Name: "payments-oncall"},
+		{Type: alert.ScheduleResponder, Name: "ops-oncall"},
+		{Type: alert.UserResponder, Username: "alice@example.com"},
+	}, renderResponders(event, responders))
+}
+
+func TestEventResponders(t *testing.T) {
+	plugin.FallbackTeam = "sre"
+	defer func() { plugin.FallbackTeam = "" }()
+	event := types.FixtureEvent("entity1", "check1")
+	responders := []alert.Responder{{Type: alert.TeamResponder, Name: "{{.Entity.Labels.owner_team}}"}}
+	assert.Equal(t, []alert.Responder{{Type: alert.TeamResponder, Name: "sre"}}, eventResponders(event, responders))
+	event.Entity.Labels = map[string]string{"owner_team": "payments"}
+	assert.Equal(t, []alert.Responder{{Type: alert.TeamResponder, Name: "payments"}}, eventResponders(event, responders))
+}
+
+func TestTemplatedRespondersEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	plugin.Team = "{{.Entity.Labels.owner_team}}"
+	plugin.VisibilityTeams = "{{.Entity.Labels.owner_team}}-managers"
+	plugin.FallbackTeam = "sre"
+	defer func() {
+		plugin.Team = ""
+		plugin.VisibilityTeams = ""
+		plugin.FallbackTeam = ""
+	}()
+	event := types.FixtureEvent("entity1", "check1")
+	event.Check.Status = 2
+	event.Entity.Labels = map[string]string{"owner_team": "payments"}
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, executeHandler(event))
+	created, ok := server.Alert("entity1/check1")
+	assert.True(t, ok)
+	assert.Equal(t, "payments", created.Responders[0].Name)
+	assert.Equal(t, "payments-managers", created.VisibleTo[0].Name)
+
+	// without the label the fallback team is used
+	event = types.FixtureEvent("entity2", "check1")
+	event.Check.Status = 2
+	assert.NoError(t, executeHandler(event))
+	created, _ = server.Alert("entity2/check1")
+	assert.Len(t, created.Responders, 1)
+	assert.Equal(t, "sre", created.Responders[0].Name)
+
+	plugin.Team = "{{.Entity.Labels.owner_team"
+	assert.Error(t, checkArgs(event))
+}
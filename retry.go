@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/client"
+)
+
+// maxRetryWait is the longest wait between two attempts of an OpsGenie API call
+const maxRetryWait = 10 * time.Second
+
+// isRetryable func returns true for errors that can succeed in a new attempt:
+// 5xx and 429 responses, timeouts and network errors. Other 4xx and validation errors are permanent
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *client.ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryWait func returns the wait before a new attempt using exponential backoff with jitter
+// --retry-backoff is doubled on each attempt and the wait is a random value between half and all of it
+func retryWait(attempt int) time.Duration {
+	backoff := time.Duration(plugin.RetryBackoff) * time.Millisecond
+	for i := 0; i < attempt && backoff < maxRetryWait; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryWait {
+		backoff = maxRetryWait
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// withRetry func calls fn until it succeeds, it returns a permanent error, --max-retries is reached
+// or ctx deadline has no time left for another attempt. The last error is returned with the operation name.
+// Each attempt is limited by --call-timeout
+func withRetry(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = callWithTimeout(ctx, fn)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return deadlineError(operation, err)
+		}
+		if !isRetryable(err) || attempt >= plugin.MaxRetries {
+			break
+		}
+		wait := retryWait(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			break
+		}
+		fmt.Printf("[WARN] %s failed, retrying in %s: %s \n", operation, wait, err)
+		select {
+		case <-ctx.Done():
+			return deadlineError(operation, err)
+		case <-time.After(wait):
+		}
+	}
+	return fmt.Errorf("%s failed: %w", operation, err)
+}
+
+// noRetryPolicy disables OpsGenie SDK retries, withRetry handles them with backoff inside the handler timeout
+func noRetryPolicy(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	return false, nil
+}
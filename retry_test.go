@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryable(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"nil", nil, false},
+		{"server error", &client.ApiError{StatusCode: 500}, true},
+		{"service unavailable", &client.ApiError{StatusCode: 503}, true},
+		{"rate limited", &client.ApiError{StatusCode: 429}, true},
+		{"unauthorized", &client.ApiError{StatusCode: 401}, false},
+		{"not found", &client.ApiError{StatusCode: 404}, false},
+		{"unprocessable", &client.ApiError{StatusCode: 422}, false},
+		{"network error", &url.Error{Op: "Post", URL: "https://api.opsgenie.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"validation", errors.New("Message can not be empty"), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isRetryable(tc.err))
+		})
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	plugin.RetryBackoff = 100
+	defer func() { plugin.RetryBackoff = 0 }()
+	for attempt, max := range []time.Duration{100, 200, 400, 800} {
+		wait := retryWait(attempt)
+		assert.GreaterOrEqual(t, int64(wait), int64(max*time.Millisecond/2))
+		assert.LessOrEqual(t, int64(wait), int64(max*time.Millisecond))
+	}
+	assert.LessOrEqual(t, int64(retryWait(20)), int64(maxRetryWait))
+}
+
+func TestWithRetry(t *testing.T) {
+	plugin.MaxRetries = 3
+	plugin.RetryBackoff = 1
+	defer func() {
+		plugin.MaxRetries = 0
+		plugin.RetryBackoff = 0
+	}()
+	ctx := context.Background()
+
+	// retryable errors are retried until success
+	attempts := 0
+	err := withRetry(ctx, "create alert", func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return &client.ApiError{StatusCode: 502}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	// permanent errors are not retried
+	attempts = 0
+	err = withRetry(ctx, "create alert", func(ctx context.Context) error {
+		attempts++
+		return &client.ApiError{StatusCode: 400}
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "create alert failed")
+	assert.Equal(t, 1, attempts)
+	var apiErr *client.ApiError
+	assert.True(t, errors.As(err, &apiErr))
+
+	// retries stop after --max-retries
+	attempts = 0
+	err = withRetry(ctx, "close alert", func(ctx context.Context) error {
+		attempts++
+		return &client.ApiError{StatusCode: 429}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 4, attempts)
+
+	// retries stop when the deadline has no time for the wait
+	plugin.RetryBackoff = 1000
+	deadline, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	attempts = 0
+	err = withRetry(deadline, "close alert", func(ctx context.Context) error {
+		attempts++
+		return &client.ApiError{StatusCode: 500}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
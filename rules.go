@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+	"gopkg.in/yaml.v2"
+)
+
+// rules file modes
+const (
+	rulesFirstMatch = "first-match"
+	rulesMerge      = "merge"
+)
+
+// rulesFile represents the --rules-file content, JSON files are read as YAML
+type rulesFile struct {
+	Mode  string        `yaml:"mode"`
+	Rules []routingRule `yaml:"rules"`
+}
+
+// routingRule represents one rule of --rules-file
+type routingRule struct {
+	Name  string      `yaml:"name"`
+	Match ruleMatch   `yaml:"match"`
+	Set   ruleActions `yaml:"set"`
+}
+
+// ruleMatch represents the conditions of a rule, all of them must match and empty ones match any event.
+// Checks, labels and annotations values accept path.Match patterns like check-*
+type ruleMatch struct {
+	Namespaces    []string          `yaml:"namespaces"`
+	EntityClasses []string          `yaml:"entity_classes"`
+	Checks        []string          `yaml:"checks"`
+	Subscriptions []string          `yaml:"subscriptions"`
+	Labels        map[string]string `yaml:"labels"`
+	Annotations   map[string]string `yaml:"annotations"`
+	Status        []uint32          `yaml:"status"`
+}
+
+// ruleActions represents what a rule sets in the alert
+type ruleActions struct {
+	Teams       []string          `yaml:"teams"`
+	Schedules   []string          `yaml:"schedules"`
+	Escalations []string          `yaml:"escalations"`
+	Responders  []string          `yaml:"responders"`
+	Visibility  []string          `yaml:"visibility"`
+	Priority    string            `yaml:"priority"`
+	Tags        []string          `yaml:"tags"`
+	Details     map[string]string `yaml:"details"`
+}
+
+// routing represents the result of matching rules with an event
+type routing struct {
+	matched     []string
+	teams       []string
+	schedules   []string
+	escalations []string
+	extra       []alert.Responder
+	visibility  []string
+	priority    alert.Priority
+	tags        []string
+	details     map[string]string
+}
+
+// loadedRules keeps --rules-file parsed by matchRules, it is read once per handler run.
+// Targets of --targets match rules concurrently, the mutex guards the cache
+var loadedRules struct {
+	sync.Mutex
+	file  string
+	rules *rulesFile
+}
+
+// resetRules func forgets --rules-file parsed by a previous handler run
+func resetRules() {
+	loadedRules.Lock()
+	defer loadedRules.Unlock()
+	loadedRules.file, loadedRules.rules = "", nil
+}
+
+// cachedRules func returns --rules-file parsed by this handler run, it reads the file the first time
+func cachedRules() (*rulesFile, error) {
+	loadedRules.Lock()
+	defer loadedRules.Unlock()
+	if loadedRules.rules != nil && loadedRules.file == plugin.RulesFile {
+		return loadedRules.rules, nil
+	}
+	rules, err := loadRules()
+	if err != nil {
+		return nil, err
+	}
+	loadedRules.file, loadedRules.rules = plugin.RulesFile, rules
+	return rules, nil
+}
+
+// loadRules func reads and validates --rules-file
+func loadRules() (*rulesFile, error) {
+	content, err := ioutil.ReadFile(plugin.RulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read --rules-file: %s", err)
+	}
+	return parseRules(content)
+}
+
+// parseRules func parses a YAML or JSON rules file and validates it
+func parseRules(content []byte) (*rulesFile, error) {
+	rules := &rulesFile{}
+	if err := yaml.UnmarshalStrict(content, rules); err != nil {
+		return nil, fmt.Errorf("invalid rules file: %s", err)
+	}
+	switch rules.Mode {
+	case "":
+		rules.Mode = rulesFirstMatch
+	case rulesFirstMatch, rulesMerge:
+	default:
+		return nil, fmt.Errorf("invalid rules file mode %q: use %s or %s", rules.Mode, rulesFirstMatch, rulesMerge)
+	}
+	for i, rule := range rules.Rules {
+		if rule.Name == "" {
+			rules.Rules[i].Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if rule.Set.Priority != "" {
+			if _, err := parsePriority(rule.Set.Priority); err != nil {
+				return nil, fmt.Errorf("rule %s invalid priority %q: %s", rules.Rules[i].Name, rule.Set.Priority, err)
+			}
+		}
+		for _, v := range rule.Set.Responders {
+			if _, err := parseResponder(v); err != nil {
+				return nil, fmt.Errorf("rule %s invalid responder: %s", rules.Rules[i].Name, err)
+			}
+		}
+		if _, err := parseVisibility(rule.Set.Visibility); err != nil {
+			return nil, fmt.Errorf("rule %s invalid visibility: %s", rules.Rules[i].Name, err)
+		}
+		patterns := append(append([]string{}, rule.Match.Checks...), mapValues(rule.Match.Labels)...)
+		for _, pattern := range append(patterns, mapValues(rule.Match.Annotations)...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %s invalid pattern %q: %s", rules.Rules[i].Name, pattern, err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// matchRules func returns what --rules-file sets for an event, nil without --rules-file.
+// first-match applies the first matching rule, merge applies all matching rules in order:
+// lists are appended and priority and details of later rules win
+func matchRules(event *types.Event) (*routing, error) {
+	if plugin.RulesFile == "" {
+		return nil, nil
+	}
+	rules, err := cachedRules()
+	if err != nil {
+		return nil, err
+	}
+	result := &routing{details: make(map[string]string)}
+	for _, rule := range rules.Rules {
+		if !rule.Match.matches(event) {
+			continue
+		}
+		result.apply(rule)
+		if rules.Mode == rulesFirstMatch {
+			break
+		}
+	}
+	return result, nil
+}
+
+// matches func returns true if the event matches every condition
+func (m ruleMatch) matches(event *types.Event) bool {
+	if event.Entity == nil || event.Check == nil {
+		return false
+	}
+	if len(m.Namespaces) != 0 && !containsString(m.Namespaces, event.Entity.Namespace) {
+		return false
+	}
+	if len(m.EntityClasses) != 0 && !containsString(m.EntityClasses, event.Entity.EntityClass) {
+		return false
+	}
+	if len(m.Checks) != 0 && !matchAny(m.Checks, event.Check.Name) {
+		return false
+	}
+	if len(m.Subscriptions) != 0 && !containsAny(m.Subscriptions, event.Check.Subscriptions) {
+		return false
+	}
+	if !matchMetadata(m.Labels, event.Check.Labels, event.Entity.Labels) {
+		return false
+	}
+	if !matchMetadata(m.Annotations, event.Check.Annotations, event.Entity.Annotations) {
+		return false
+	}
+	if len(m.Status) != 0 {
+		for _, status := range m.Status {
+			if status == event.Check.Status {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// apply func adds what a rule sets to the routing
+func (r *routing) apply(rule routingRule) {
+	r.matched = append(r.matched, rule.Name)
+	r.teams = appendUnique(r.teams, rule.Set.Teams...)
+	r.schedules = appendUnique(r.schedules, rule.Set.Schedules...)
+	r.escalations = appendUnique(r.escalations, rule.Set.Escalations...)
+	for _, v := range rule.Set.Responders {
+		responder, _ := parseResponder(v)
+		r.extra = append(r.extra, responder)
+	}
+	r.visibility = appendUnique(r.visibility, rule.Set.Visibility...)
+	r.tags = appendUnique(r.tags, rule.Set.Tags...)
+	if rule.Set.Priority != "" {
+		r.priority, _ = parsePriority(rule.Set.Priority)
+	}
+	for k, v := range rule.Set.Details {
+		r.details[k] = v
+	}
+}
+
+// matchMetadata func returns true if every key has a matching value in check or entity metadata, check first
+func matchMetadata(patterns, check, entity map[string]string) bool {
+	for key, pattern := range patterns {
+		value, ok := check[key]
+		if !ok {
+			value, ok = entity[key]
+		}
+		if !ok || !matchAny([]string{pattern}, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny func returns true if value matches one of the path.Match patterns
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString func returns true if list has value, case insensitive
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAny func returns true if list and values have a common value
+func containsAny(list, values []string) bool {
+	for _, v := range values {
+		if containsString(list, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendUnique func appends values that are not in list yet
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		if v != "" && !containsString(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// missingTags func returns the tags that are not in list yet
+func missingTags(list, tags []string) []string {
+	missing := []string{}
+	for _, v := range tags {
+		if v != "" && !containsString(list, v) {
+			missing = append(missing, v)
+		}
+	}
+	return missing
+}
+
+// mapValues func returns the values of a map
+func mapValues(m map[string]string) []string {
+	values := []string{}
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return values
+}
+
+// responders func returns the alert responders: teams, schedules and escalations set by rules
+// replace --team, --schedule-team and --escalation-team, the others keep the flags.
+// With an active --follow-the-sun shift, rules replace the shift responders of the types they set
+// and the other shift responders are kept. Responders set by rules are added to --responder
+func (r *routing) responders(shift *shift) []alert.Responder {
+	if r == nil {
+		return shiftResponders(shift)
+	}
+	if len(r.teams) == 0 && len(r.schedules) == 0 && len(r.escalations) == 0 {
+		return append(shiftResponders(shift), r.extra...)
+	}
+	if shift != nil && len(shift.Responders) != 0 {
+		local := responderList(alert.EscalationResponder, r.escalations)
+		local = append(local, responderList(alert.ScheduleResponder, r.schedules)...)
+		local = append(local, responderList(alert.TeamResponder, r.teams)...)
+		for _, responder := range shift.responders() {
+			if !r.replaces(responder.Type) {
+				local = append(local, responder)
+			}
+		}
+		fmt.Printf("Follow-the-sun shift %s: routing rules replace its %s responders \n", shift.Name, strings.Join(r.replacedTypes(), ", "))
+		local = append(local, flagResponders()...)
+		return append(local, r.extra...)
+	}
+	escalations := splitStringInSlice(plugin.EscalationTeam)
+	if len(r.escalations) != 0 {
+		escalations = r.escalations
+	}
+	schedules := splitStringInSlice(plugin.ScheduleTeam)
+	if len(r.schedules) != 0 {
+		schedules = r.schedules
+	}
+	teams := splitStringInSlice(plugin.Team)
+	if len(r.teams) != 0 {
+		teams = r.teams
+	}
+	local := responderList(alert.EscalationResponder, escalations)
+	local = append(local, responderList(alert.ScheduleResponder, schedules)...)
+	local = append(local, responderList(alert.TeamResponder, teams)...)
+	local = append(local, flagResponders()...)
+	return append(local, r.extra...)
+}
+
+// replaces func returns true if rules set responders of this type
+func (r *routing) replaces(responderType alert.ResponderType) bool {
+	switch responderType {
+	case alert.TeamResponder:
+		return len(r.teams) != 0
+	case alert.ScheduleResponder:
+		return len(r.schedules) != 0
+	case alert.EscalationResponder:
+		return len(r.escalations) != 0
+	}
+	return false
+}
+
+// replacedTypes func returns the responder types set by rules
+func (r *routing) replacedTypes() []string {
+	replaced := []string{}
+	for _, responderType := range []alert.ResponderType{alert.EscalationResponder, alert.ScheduleResponder, alert.TeamResponder} {
+		if r.replaces(responderType) {
+			replaced = append(replaced, string(responderType))
+		}
+	}
+	return replaced
+}
+
+// visibleTo func returns the visibility teams and users set by rules, or the shift visibility or --visibility-teams
+func (r *routing) visibleTo(shift *shift) []alert.Responder {
+	if r == nil || len(r.visibility) == 0 {
+		return shiftVisibility(shift)
+	}
+	visibility, _ := parseVisibility(r.visibility)
+	return visibility
+}
+
+// responderList func returns a responder of responderType for each name
+func responderList(responderType alert.ResponderType, names []string) []alert.Responder {
+	local := []alert.Responder{}
+	for _, v := range names {
+		if v != "" {
+			local = append(local, alert.Responder{Type: responderType, Name: v})
+		}
+	}
+	return local
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// useRules sets --rules-file to a file of tests directory until the test ends
+func useRules(t *testing.T, name string) {
+	plugin.RulesFile = filepath.Join("tests", name)
+	t.Cleanup(func() {
+		plugin.RulesFile = ""
+	})
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := parseRules([]byte("rules:\n  - match: {checks: [check-nginx]}\n    set: {priority: p2}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, rulesFirstMatch, rules.Mode)
+	assert.Equal(t, "rule-1", rules.Rules[0].Name)
+
+	invalid := []string{
+		"mode: all",
+		"rules:\n  - set: {priority: P9}",
+		"rules:\n  - match: {checks: [\"check-[\"]}",
+		"rules:\n  - match: {check: [check-nginx]}",
+		"rules: [",
+	}
+	for _, v := range invalid {
+		_, err := parseRules([]byte(v))
+		assert.Error(t, err, v)
+	}
+}
+
+func TestMatchRulesFirstMatch(t *testing.T) {
+	useRules(t, "rules.yaml")
+	testCases := []struct {
+		fixture     string
+		matched     []string
+		priority    alert.Priority
+		teams       []string
+		escalations []string
+		tags        []string
+	}{
+		{"event_from_proxy", []string{"kubernetes"}, alert.P1, []string{"k8s-sre"}, nil, []string{"kubernetes"}},
+		{"event_new", []string{"certificates"}, alert.P2, []string{"security"}, nil, []string{"certificates"}},
+		{"event.withAnnotations", []string{"documented-web"}, "", nil, []string{"web-escalation"}, []string{"web", "documented"}},
+		{"event", []string{"web"}, "", []string{"web"}, nil, []string{"web"}},
+	}
+	for _, tc := range testCases {
+		routing, err := matchRules(readFixture(t, tc.fixture+".json"))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.matched, routing.matched, tc.fixture)
+		assert.Equal(t, tc.priority, routing.priority, tc.fixture)
+		assert.Equal(t, tc.teams, routing.teams, tc.fixture)
+		assert.Equal(t, tc.escalations, routing.escalations, tc.fixture)
+		assert.Equal(t, tc.tags, routing.tags, tc.fixture)
+	}
+
+	// resolved proxy event does not match status 2
+	routing, err := matchRules(readFixture(t, "event_from_proxy.resolved.json"))
+	assert.NoError(t, err)
+	assert.NotContains(t, routing.matched, "kubernetes")
+}
+
+func TestMatchRulesReadOnce(t *testing.T) {
+	content, err := ioutil.ReadFile(filepath.Join("tests", "rules.yaml"))
+	assert.NoError(t, err)
+	plugin.RulesFile = filepath.Join(t.TempDir(), "rules.yaml")
+	defer func() {
+		plugin.RulesFile = ""
+		resetRules()
+	}()
+	assert.NoError(t, ioutil.WriteFile(plugin.RulesFile, content, 0600))
+	event := readFixture(t, "event_from_proxy.json")
+	first, err := matchRules(event)
+	assert.NoError(t, err)
+
+	// the file is not read again during the handler run
+	assert.NoError(t, os.Remove(plugin.RulesFile))
+	second, err := matchRules(event)
+	assert.NoError(t, err)
+	assert.Equal(t, first.matched, second.matched)
+
+	// the next handler run reads it again
+	resetRules()
+	_, err = matchRules(event)
+	assert.Error(t, err)
+}
+
+func TestMatchRulesMerge(t *testing.T) {
+	useRules(t, "rules.json")
+	routing, err := matchRules(readFixture(t, "event.withAnnotations.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"documented-web", "web"}, routing.matched)
+	assert.Equal(t, []string{"web", "documented"}, routing.tags)
+	assert.Equal(t, []string{"web"}, routing.teams)
+	assert.Equal(t, []string{"web-escalation"}, routing.escalations)
+
+	routing, err = matchRules(readFixture(t, "event_from_proxy.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kubernetes"}, routing.matched)
+	assert.Equal(t, "https://runbooks.example.com/kubernetes", routing.details["runbook"])
+}
+
+func TestRoutingResponders(t *testing.T) {
+	plugin.Team = "sre"
+	plugin.ScheduleTeam = "oncall"
+	plugin.VisibilityTeams = "managers"
+	defer func() {
+		plugin.Team = ""
+		plugin.ScheduleTeam = ""
+		plugin.VisibilityTeams = ""
+	}()
+	var none *routing
+	assert.Equal(t, respondersTeam(), none.responders(nil))
+	assert.Equal(t, visibilityTeams(), none.visibleTo(nil))
+
+	r := &routing{teams: []string{"web"}, escalations: []string{"web-escalation"}}
+	assert.Equal(t, []alert.Responder{
+		{Type: alert.EscalationResponder, Name: "web-escalation"},
+		{Type: alert.ScheduleResponder, Name: "oncall"},
+		{Type: alert.TeamResponder, Name: "web"},
+	}, r.responders(nil))
+	assert.Equal(t, []alert.Responder{{Type: alert.TeamResponder, Name: "managers"}}, r.visibleTo(nil))
+	r.visibility = []string{"web-managers"}
+	assert.Equal(t, []alert.Responder{{Type: alert.TeamResponder, Name: "web-managers"}}, r.visibleTo(nil))
+}
+
+func TestRoutingRespondersFollowTheSun(t *testing.T) {
+	// 02:00 UTC is the apac shift: team:apac-sre and schedule:apac-oncall
+	useFollowTheSun(t, 2, 0)
+	shift := activeShift(nil)
+	plugin.Team = "sre"
+	defer func() { plugin.Team = "" }()
+
+	// rules without teams, schedules or escalations keep the shift
+	r := &routing{}
+	assert.Equal(t, []alert.Responder{
+		{Type: alert.TeamResponder, Name: "apac-sre"},
+		{Type: alert.ScheduleResponder, Name: "apac-oncall"},
+	}, r.responders(shift))
+
+	// rule teams replace the shift teams, the shift schedule is kept and --team is not used
+	r = &routing{teams: []string{"web"}}
+	assert.Equal(t, []alert.Responder{
+		{Type: alert.TeamResponder, Name: "web"},
+		{Type: alert.ScheduleResponder, Name: "apac-oncall"},
+	}, r.responders(shift))
+
+	r = &routing{escalations: []string{"web-escalation"}, schedules: []string{"web-oncall"}}
+	assert.Equal(t, []alert.Responder{
+		{Type: alert.EscalationResponder, Name: "web-escalation"},
+		{Type: alert.ScheduleResponder, Name: "web-oncall"},
+		{Type: alert.TeamResponder, Name: "apac-sre"},
+	}, r.responders(shift))
+}
+
+func TestRulesPriority(t *testing.T) {
+	useRules(t, "rules.yaml")
+	plugin.Priority = "P3"
+	defer func() { plugin.Priority = "" }()
+	event := readFixture(t, "event_new.json")
+	// annotations override rules
+	assert.Equal(t, alert.P3, eventPriority(event))
+	// rule priority overrides --priority
+	event.Check.Annotations = nil
+	assert.Equal(t, alert.P2, eventPriority(event))
+}
+
+func TestRulesEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	useRules(t, "rules.yaml")
+	event := readFixture(t, "event_from_proxy.json")
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, executeHandler(event))
+	created, ok := server.Alert("k8s.example.com/nodes-ready-k8s-prod")
+	assert.True(t, ok)
+	// the priority annotation of the fixture overrides the rule
+	assert.Equal(t, "P2", created.Priority)
+	assert.Contains(t, created.Tags, "kubernetes")
+	assert.Equal(t, "https://runbooks.example.com/kubernetes", created.Details["runbook"])
+	assert.Len(t, created.Responders, 2)
+	assert.Equal(t, "k8s-oncall", created.Responders[0].Name)
+	assert.Equal(t, "k8s-sre", created.Responders[1].Name)
+	assert.Equal(t, "platform-managers", created.VisibleTo[0].Name)
+
+	plugin.RulesFile = filepath.Join("tests", "missing.yaml")
+	assert.Error(t, checkArgs(types.FixtureEvent("entity1", "check1")))
+}
+
+func TestRulesResponders(t *testing.T) {
+	rules, err := parseRules([]byte("rules:\n  - set: {responders: [\"user:alice@example.com\"], visibility: [sre, \"user:bob@example.com\"]}\n"))
+	assert.NoError(t, err)
+	r := &routing{}
+	r.apply(rules.Rules[0])
+	assert.Equal(t, []alert.Responder{{Type: alert.UserResponder, Username: "alice@example.com"}}, r.responders(nil))
+	assert.Equal(t, []alert.Responder{
+		{Type: alert.TeamResponder, Name: "sre"},
+		{Type: alert.UserResponder, Username: "bob@example.com"},
+	}, r.visibleTo(nil))
+
+	for _, v := range []string{"rules:\n  - set: {responders: [sre]}", "rules:\n  - set: {visibility: [\"schedule:primary\"]}"} {
+		_, err := parseRules([]byte(v))
+		assert.Error(t, err, v)
+	}
+}
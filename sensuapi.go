@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+)
+
+// snoozeTolerance is how much earlier than the silence expiry an alert can be snoozed until without snoozing it again,
+// expire is read in seconds left and moves a little between handler runs
+const snoozeTolerance = time.Minute
+
+// sensuSilence represents the fields of a Sensu silenced entry used to find its expiry and what it silences.
+// expire is the number of seconds left, -1 without expiry, expire_at is set by recent Sensu versions
+type sensuSilence struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Expire       int64  `json:"expire"`
+	ExpireAt     int64  `json:"expire_at"`
+	Begin        int64  `json:"begin"`
+	Subscription string `json:"subscription"`
+	Check        string `json:"check"`
+	Reason       string `json:"reason"`
+}
+
+// expiry func returns when the silence ends, zero without expiry
+func (s sensuSilence) expiry() time.Time {
+	if s.ExpireAt > 0 {
+		return time.Unix(s.ExpireAt, 0)
+	}
+	if s.Expire > 0 {
+		return now().Add(time.Duration(s.Expire) * time.Second).Truncate(time.Second)
+	}
+	return time.Time{}
+}
+
+// checkSensuAPI func validates --snooze-silenced and --sensu-api-url
+func checkSensuAPI() error {
+	if plugin.SensuAPIURL != "" {
+		u, err := url.Parse(plugin.SensuAPIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid --sensu-api-url %q: use http(s)://host:port", plugin.SensuAPIURL)
+		}
+	}
+	if plugin.SnoozeSilenced && plugin.SensuAPIURL == "" {
+		return fmt.Errorf("--snooze-silenced requires --sensu-api-url")
+	}
+	return nil
+}
+
+// sensuGet func reads a Sensu API path like /api/core/v2/silenced in value
+func sensuGet(ctx context.Context, path string, value interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(plugin.SensuAPIURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	if plugin.SensuAPIKey != "" {
+		req.Header.Set("Authorization", "Key "+plugin.SensuAPIKey)
+	}
+	httpClient := &http.Client{Timeout: callTimeout()}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Sensu API answered %d %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, value)
+}
+
+// getSilence func reads a silenced entry from Sensu API
+func getSilence(ctx context.Context, namespace, name string) (*sensuSilence, error) {
+	silence := &sensuSilence{}
+	path := fmt.Sprintf("/api/core/v2/namespaces/%s/silenced/%s", url.PathEscape(namespace), url.PathEscape(name))
+	if err := sensuGet(ctx, path, silence); err != nil {
+		return nil, fmt.Errorf("cannot get silence %s: %s", name, err)
+	}
+	return silence, nil
+}
+
+// silenceExpiry func returns when the last silence entry of the event ends, the event is silenced until then.
+// It returns zero if one entry has no expiry
+func silenceExpiry(ctx context.Context, event *types.Event) (time.Time, error) {
+	var expiry time.Time
+	for _, name := range event.Check.Silenced {
+		silence, err := getSilence(ctx, event.Entity.Namespace, name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		end := silence.expiry()
+		if end.IsZero() {
+			fmt.Printf("Silence %s has no expiry \n", name)
+			return time.Time{}, nil
+		}
+		if end.After(expiry) {
+			expiry = end
+		}
+	}
+	return expiry, nil
+}
+
+// snoozeSilenced func snoozes an open alert until the silence entries of the event expire
+func snoozeSilenced(ctx context.Context, alertClient AlertAPI, event *types.Event, openAlert *alert.GetAlertResult) error {
+	expiry, err := silenceExpiry(ctx, event)
+	if err != nil {
+		return err
+	}
+	switch {
+	case expiry.IsZero():
+		fmt.Printf("Not snoozing alert %s: silence without expiry \n", openAlert.Id)
+		return nil
+	case !expiry.After(now()):
+		fmt.Printf("Not snoozing alert %s: silence expired at %s \n", openAlert.Id, expiry.UTC().Format(time.RFC3339))
+		return nil
+	case openAlert.Snoozed && !openAlert.SnoozedUntil.Before(expiry.Add(-snoozeTolerance)):
+		fmt.Printf("Alert %s already snoozed until %s \n", openAlert.Id, openAlert.SnoozedUntil.UTC().Format(time.RFC3339))
+		return nil
+	}
+	var snoozeResult *alert.AsyncAlertResult
+	err = withRetry(ctx, "snooze alert "+openAlert.Id, func(ctx context.Context) (err error) {
+		snoozeResult, err = alertClient.Snooze(ctx, &alert.SnoozeAlertRequest{
+			IdentifierType:  alert.ALERTID,
+			IdentifierValue: openAlert.Id,
+			EndTime:         expiry.UTC(),
+			Source:          source,
+			Note:            fmt.Sprintf("%s until %s", silencedNote(event), expiry.UTC().Format(time.RFC3339)),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("RequestID %s to Snooze %s until %s \n", openAlert.Id, snoozeResult.RequestId, expiry.UTC().Format(time.RFC3339))
+	if plugin.Verify {
+		return verifyRequest(ctx, alertClient, "snooze alert "+openAlert.Id, snoozeResult.RequestId)
+	}
+	return nil
+}
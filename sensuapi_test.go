@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useSensuAPI starts a Sensu API stand-in serving silences of namespace default,
+// and sets --sensu-api-url and --sensu-api-key until the test ends
+func useSensuAPI(t *testing.T, silences map[string]sensuSilence) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Key sensu-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/api/core/v2/namespaces/default/silenced/")
+		silence, ok := silences[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"not found"}`))
+			return
+		}
+		silence.Metadata.Name = name
+		_ = json.NewEncoder(w).Encode(silence)
+	}))
+	plugin.SensuAPIURL = server.URL
+	plugin.SensuAPIKey = "sensu-key"
+	t.Cleanup(func() {
+		server.Close()
+		plugin.SensuAPIURL = ""
+		plugin.SensuAPIKey = ""
+	})
+}
+
+func TestCheckSensuAPI(t *testing.T) {
+	defer func() {
+		plugin.SnoozeSilenced = false
+		plugin.SensuAPIURL = ""
+	}()
+	plugin.SnoozeSilenced = true
+	assert.Error(t, checkSensuAPI())
+	plugin.SensuAPIURL = "sensu-backend:8080"
+	assert.Error(t, checkSensuAPI())
+	plugin.SensuAPIURL = "http://127.0.0.1:8080"
+	assert.NoError(t, checkSensuAPI())
+}
+
+func TestSilenceExpiry(t *testing.T) {
+	now = func() time.Time { return time.Date(2021, 8, 3, 10, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+	useSensuAPI(t, map[string]sensuSilence{
+		"entity:entity1:*": {Expire: 3600},
+		"*:check1":         {Expire: 600, ExpireAt: time.Date(2021, 8, 3, 12, 0, 0, 0, time.UTC).Unix()},
+		"linux:*":          {Expire: -1},
+	})
+	event := silencedFixture("entity1", "entity:entity1:*", "*:check1")
+	expiry, err := silenceExpiry(context.Background(), event)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 8, 3, 12, 0, 0, 0, time.UTC), expiry.UTC())
+
+	expiry, err = silenceExpiry(context.Background(), silencedFixture("entity1", "entity:entity1:*", "linux:*"))
+	assert.NoError(t, err)
+	assert.True(t, expiry.IsZero())
+
+	_, err = silenceExpiry(context.Background(), silencedFixture("entity1", "missing:*"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+
+	plugin.SensuAPIKey = "wrong"
+	_, err = silenceExpiry(context.Background(), event)
+	assert.Error(t, err)
+}
+
+func TestSnoozeSilenced(t *testing.T) {
+	server := useMockServer(t)
+	useSensuAPI(t, map[string]sensuSilence{
+		"entity:entity1:*": {Expire: 3600},
+		"linux:*":          {Expire: -1},
+	})
+	plugin.SnoozeSilenced = true
+	defer func() { plugin.SnoozeSilenced = false }()
+	assert.NoError(t, checkArgs(silencedFixture("entity1")))
+	assert.NoError(t, executeHandler(silencedFixture("entity1")))
+
+	before := time.Now()
+	assert.NoError(t, executeHandler(silencedFixture("entity1", "entity:entity1:*")))
+	snoozed, _ := server.Alert("entity1/check1")
+	assert.True(t, snoozed.Snoozed)
+	assert.False(t, snoozed.Acknowledged)
+	assert.WithinDuration(t, before.Add(time.Hour), snoozed.SnoozedUntil, 5*time.Second)
+	assert.Contains(t, snoozed.Notes[0], "Silenced in Sensu by entity:entity1:* until ")
+
+	// the alert is snoozed once for the same expiry
+	assert.NoError(t, executeHandler(silencedFixture("entity1", "entity:entity1:*")))
+	snoozed, _ = server.Alert("entity1/check1")
+	assert.Len(t, snoozed.Notes, 1)
+
+	// resolving the check closes the alert and ends the snooze
+	event := silencedFixture("entity1", "entity:entity1:*")
+	event.Check.Status = 0
+	assert.NoError(t, executeHandler(event))
+	closed, _ := server.Alert("entity1/check1")
+	assert.Equal(t, "closed", closed.Status)
+	assert.False(t, closed.Snoozed)
+
+	// silences without expiry do not snooze
+	assert.NoError(t, executeHandler(silencedFixture("entity2")))
+	assert.NoError(t, executeHandler(silencedFixture("entity2", "linux:*")))
+	created, _ := server.Alert("entity2/check1")
+	assert.False(t, created.Snoozed)
+
+	// a silence that cannot be read fails the handler
+	assert.Error(t, executeHandler(silencedFixture("entity2", "missing:*")))
+}
+
+func TestSensuSilenceExpiry(t *testing.T) {
+	now = func() time.Time { return time.Date(2021, 8, 3, 10, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+	assert.Equal(t, time.Date(2021, 8, 3, 10, 1, 0, 0, time.UTC), sensuSilence{Expire: 60}.expiry().UTC())
+	assert.True(t, sensuSilence{Expire: -1}.expiry().IsZero())
+	assert.Equal(t, int64(1628000000), sensuSilence{ExpireAt: 1628000000}.expiry().Unix())
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+)
+
+// isSilenced func returns true if an operator silenced the entity or check of the event
+func isSilenced(event *types.Event) bool {
+	return event.Check != nil && (event.Check.IsSilenced || len(event.Check.Silenced) != 0)
+}
+
+// silencedHandled func returns true if --acknowledge-silenced, --snooze-silenced or --skip-silenced applies to the event
+func silencedHandled(event *types.Event) bool {
+	return (plugin.AcknowledgeSilenced || plugin.SnoozeSilenced || plugin.SkipSilenced) && isSilenced(event)
+}
+
+// silencedNote func returns the note naming the silence entries of the event
+func silencedNote(event *types.Event) string {
+	if len(event.Check.Silenced) == 0 {
+		return "Silenced in Sensu"
+	}
+	return fmt.Sprintf("Silenced in Sensu by %s", strings.Join(event.Check.Silenced, ", "))
+}
+
+// silencedEvent func handles a silenced event: with --acknowledge-silenced an open alert is acknowledged
+// with a note naming the silence entries, with --snooze-silenced it is snoozed until the silence expires,
+// and with --skip-silenced no alert is created. Without an open alert and --skip-silenced the alert is created as usual,
+// unless --flapping-policy hold holds it
+func silencedEvent(ctx context.Context, alertClient AlertAPI, event *types.Event) error {
+	_, alias, _ := parseEventKeyTags(event)
+	openAlert, err := findAlert(ctx, alertClient, alias)
+	if err != nil {
+		return err
+	}
+	if openAlert == nil || openAlert.Status != "open" {
+		if plugin.SkipSilenced {
+			fmt.Printf("Not creating alert %s: %s \n", alias, silencedNote(event))
+			return nil
+		}
+		// --flapping-policy hold creates no alert while the check is flapping, silenced or not
+		if flappingHeld(event) {
+			fmt.Printf("Flapping: holding alert %s, %d state changes in history \n", alias, stateChanges(event.Check.History))
+			return nil
+		}
+		// the alert was already looked up, it is not read again to create it
+		return alertEventWith(ctx, alertClient, event, openAlert)
+	}
+	if !plugin.AcknowledgeSilenced && !plugin.SnoozeSilenced {
+		fmt.Printf("Not updating alert %s: %s \n", alias, silencedNote(event))
+		return nil
+	}
+	if plugin.SnoozeSilenced {
+		if err := snoozeSilenced(ctx, alertClient, event, openAlert); err != nil {
+			return err
+		}
+	}
+	if !plugin.AcknowledgeSilenced {
+		return nil
+	}
+	if openAlert.Acknowledged {
+		fmt.Printf("Alert %s already acknowledged \n", openAlert.Id)
+		return nil
+	}
+	return acknowledgeAlert(ctx, alertClient, openAlert.Id, silencedNote(event))
+}
+
+// acknowledgeAlert func acknowledges an alert with a note
+func acknowledgeAlert(ctx context.Context, alertClient AlertAPI, alertid, note string) error {
+	var ackResult *alert.AsyncAlertResult
+	err := withRetry(ctx, "acknowledge alert "+alertid, func(ctx context.Context) (err error) {
+		ackResult, err = alertClient.Acknowledge(ctx, &alert.AcknowledgeAlertRequest{
+			IdentifierType:  alert.ALERTID,
+			IdentifierValue: alertid,
+			Source:          source,
+			Note:            note,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("RequestID %s to Acknowledge %s \n", alertid, ackResult.RequestId)
+	if plugin.Verify {
+		return verifyRequest(ctx, alertClient, "acknowledge alert "+alertid, ackResult.RequestId)
+	}
+	return nil
+}
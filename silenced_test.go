@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// useSilenced sets --acknowledge-silenced and --skip-silenced until the test ends
+func useSilenced(t *testing.T, acknowledge, skip bool) {
+	plugin.AcknowledgeSilenced = acknowledge
+	plugin.SkipSilenced = skip
+	t.Cleanup(func() {
+		plugin.AcknowledgeSilenced = false
+		plugin.SkipSilenced = false
+	})
+}
+
+// silencedFixture returns a critical event silenced by entries
+func silencedFixture(entity string, entries ...string) *types.Event {
+	event := types.FixtureEvent(entity, "check1")
+	event.Check.Status = 2
+	event.Check.IsSilenced = len(entries) != 0
+	event.Check.Silenced = entries
+	return event
+}
+
+func TestHandlerBranchSilenced(t *testing.T) {
+	event := silencedFixture("entity1", "entity:entity1:*")
+	assert.Equal(t, branchCreate, handlerBranch(event))
+	useSilenced(t, true, false)
+	assert.Equal(t, branchSilenced, handlerBranch(event))
+	assert.Equal(t, branchCreate, handlerBranch(silencedFixture("entity1")))
+	event.Check.Status = 0
+	assert.Equal(t, branchClose, handlerBranch(event))
+	assert.Equal(t, "Silenced in Sensu by entity:entity1:*, *:check1", silencedNote(silencedFixture("entity1", "entity:entity1:*", "*:check1")))
+}
+
+func TestAcknowledgeSilenced(t *testing.T) {
+	server := useMockServer(t)
+	useSilenced(t, true, false)
+	assert.NoError(t, executeHandler(silencedFixture("entity1")))
+	created, ok := server.Alert("entity1/check1")
+	assert.True(t, ok)
+	assert.False(t, created.Acknowledged)
+
+	assert.NoError(t, executeHandler(silencedFixture("entity1", "entity:entity1:*")))
+	acknowledged, _ := server.Alert("entity1/check1")
+	assert.True(t, acknowledged.Acknowledged)
+	assert.Equal(t, 1, acknowledged.Count)
+	assert.Equal(t, []string{"Silenced in Sensu by entity:entity1:*"}, acknowledged.Notes)
+
+	// an acknowledged alert is not acknowledged again
+	assert.NoError(t, executeHandler(silencedFixture("entity1", "entity:entity1:*")))
+	acknowledged, _ = server.Alert("entity1/check1")
+	assert.Len(t, acknowledged.Notes, 1)
+
+	// without an alert a silenced event creates it
+	assert.NoError(t, executeHandler(silencedFixture("entity2", "entity:entity2:*")))
+	_, ok = server.Alert("entity2/check1")
+	assert.True(t, ok)
+}
+
+func TestSkipSilenced(t *testing.T) {
+	server := useMockServer(t)
+	useSilenced(t, false, true)
+	assert.NoError(t, executeHandler(silencedFixture("entity1", "entity:entity1:*")))
+	_, ok := server.Alert("entity1/check1")
+	assert.False(t, ok)
+
+	// an open alert is not updated or acknowledged without --acknowledge-silenced
+	assert.NoError(t, executeHandler(silencedFixture("entity1")))
+	assert.NoError(t, executeHandler(silencedFixture("entity1", "entity:entity1:*")))
+	created, _ := server.Alert("entity1/check1")
+	assert.Equal(t, 1, created.Count)
+	assert.False(t, created.Acknowledged)
+
+	// resolved silenced events still close the alert
+	event := silencedFixture("entity1", "entity:entity1:*")
+	event.Check.Status = 0
+	assert.NoError(t, executeHandler(event))
+	closed, _ := server.Alert("entity1/check1")
+	assert.Equal(t, "closed", closed.Status)
+}
+
+func TestSilencedEscalation(t *testing.T) {
+	useSilenced(t, true, false)
+	plugin.AliasTemplate = "{{.Entity.Name}}/{{.Check.Name}}"
+	plugin.EscalationRules = "1=P2:sre"
+	plugin.UpdateOnStatusChange = true
+	defer func() {
+		plugin.EscalationRules = ""
+		plugin.UpdateOnStatusChange = false
+	}()
+	alerts := map[string]*alert.GetAlertResult{"entity1/check1": {Id: "alert-id", Status: "open", Priority: alert.P3, Details: map[string]string{"status": "1"}}}
+
+	// an event that is not silenced
+	event := silencedFixture("entity1")
+	event.Check.Occurrences = 1
+	created := &fakeAlertAPI{alerts: alerts}
+	assert.NoError(t, alertEvent(context.Background(), created, event))
+
+	// silenced without an open alert takes the same path, the alert is read once
+	alerts["entity1/check1"].Status = "closed"
+	event = silencedFixture("entity1", "entity:entity1:*")
+	event.Check.Occurrences = 1
+	silenced := &fakeAlertAPI{alerts: alerts}
+	assert.NoError(t, silencedEvent(context.Background(), silenced, event))
+	plain := &fakeAlertAPI{alerts: alerts}
+	assert.NoError(t, alertEvent(context.Background(), plain, event))
+	assert.Equal(t, plain.calls, silenced.calls)
+	assert.Contains(t, created.calls, "AddResponder alert-id sre")
+}
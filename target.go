@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// policies accepted by --target-policy
+const (
+	targetPolicyAll     = "all"
+	targetPolicyPrimary = "primary"
+)
+
+// target represents the OpsGenie account used by a handler run, one per --targets entry
+type target struct {
+	name      string
+	tokenEnv  string
+	token     string
+	region    string
+	outboxDir string
+	// clients are created by fanOut before target goroutines start,
+	// the OpsGenie SDK sets a package variable when a client is created
+	alertClient     AlertAPI
+	heartbeatClient HeartbeatAPI
+}
+
+// targetKey saves the target of a fan-out run in the context
+type targetKey struct{}
+
+// withTarget func returns a context that sends OpsGenie calls of ctx to t
+func withTarget(ctx context.Context, t target) context.Context {
+	return context.WithValue(ctx, targetKey{}, t)
+}
+
+// currentTarget func returns the target saved in ctx by a fan-out run,
+// or the global --auth, --region and --outbox-dir
+func currentTarget(ctx context.Context) target {
+	if t, ok := ctx.Value(targetKey{}).(target); ok {
+		return t
+	}
+	return target{token: plugin.AuthToken, region: plugin.APIRegion, outboxDir: plugin.OutboxDir}
+}
+
+// parseTargets func parses primary=OPSGENIE_AUTHTOKEN:us,new=OPSGENIE_NEW_TOKEN:eu in a list of target,
+// the first one is the primary target. Region is optional and defaults to --region
+func parseTargets(s string) ([]target, error) {
+	targets := []target{}
+	names := make(map[string]bool)
+	for _, v := range splitStringInSlice(s) {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		key, value := splitString(v, "=")
+		name := strings.TrimSpace(key)
+		if name == "" || value == "" {
+			return targets, fmt.Errorf("target wrong format %q: name=TOKEN_ENV_VAR:region", v)
+		}
+		if !tenantName.MatchString(name) {
+			return targets, fmt.Errorf("target invalid name %q: use letters, numbers, - and _", name)
+		}
+		if names[name] {
+			return targets, fmt.Errorf("target duplicated name %q", name)
+		}
+		names[name] = true
+		fields := strings.Split(value, ":")
+		if len(fields) > 2 || fields[0] == "" {
+			return targets, fmt.Errorf("target wrong format %q: name=TOKEN_ENV_VAR:region", v)
+		}
+		t := target{name: name, tokenEnv: fields[0], region: plugin.APIRegion}
+		if len(fields) == 2 && fields[1] != "" {
+			t.region = strings.ToLower(fields[1])
+			if t.region != "us" && t.region != "eu" {
+				return targets, fmt.Errorf("target invalid region %q for %q: use us or eu", fields[1], name)
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// checkTargets func validates --targets and --target-policy
+func checkTargets() error {
+	if _, err := parseTargets(plugin.Targets); err != nil {
+		return err
+	}
+	switch plugin.TargetPolicy {
+	case "", targetPolicyAll, targetPolicyPrimary:
+		return nil
+	}
+	return fmt.Errorf("invalid --target-policy %s: use %s or %s", plugin.TargetPolicy, targetPolicyAll, targetPolicyPrimary)
+}
+
+// resolveTarget func reads the target token and sets its outbox sub directory
+func resolveTarget(t target) (target, error) {
+	t.token = os.Getenv(t.tokenEnv)
+	if t.token == "" {
+		return t, fmt.Errorf("authentication token env var %s is empty", t.tokenEnv)
+	}
+	if plugin.OutboxDir != "" {
+		t.outboxDir = filepath.Join(plugin.OutboxDir, t.name)
+	}
+	return t, nil
+}
+
+// targetClients func creates the alert and heartbeat clients of a target
+func targetClients(ctx context.Context, t target) (target, error) {
+	alertClient, err := targetAlertClient(withTarget(ctx, t))
+	if err != nil {
+		return t, fmt.Errorf("failed to create opsgenie client: %s", err)
+	}
+	heartbeatClient, err := targetHeartbeatClient(withTarget(ctx, t))
+	if err != nil {
+		return t, fmt.Errorf("failed to create opsgenie heartbeat client: %s", err)
+	}
+	t.alertClient, t.heartbeatClient = alertClient, heartbeatClient
+	return t, nil
+}
+
+// fanOut func runs handle for every target concurrently and reports failures of each target.
+// With --target-policy primary only the first target must succeed, others are best-effort
+func fanOut(ctx context.Context, targets []target, handle func(ctx context.Context) error) error {
+	errs := make([]error, len(targets))
+	resolved := make([]target, len(targets))
+	for i := range targets {
+		t, err := resolveTarget(targets[i])
+		if err == nil {
+			t, err = targetClients(ctx, t)
+		}
+		resolved[i], errs[i] = t, err
+	}
+	var wg sync.WaitGroup
+	for i := range resolved {
+		if errs[i] != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = handle(withTarget(ctx, resolved[i]))
+		}(i)
+	}
+	wg.Wait()
+
+	failed := []string{}
+	for i, err := range errs {
+		if err == nil {
+			fmt.Printf("Target %s done \n", targets[i].name)
+			continue
+		}
+		if plugin.TargetPolicy == targetPolicyPrimary && i != 0 {
+			fmt.Printf("[WARN] Target %s failed (best-effort): %s \n", targets[i].name, err)
+			continue
+		}
+		fmt.Printf("[ERROR] Target %s failed: %s \n", targets[i].name, err)
+		failed = append(failed, fmt.Sprintf("%s: %s", targets[i].name, err))
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("%d of %d targets failed: %s", len(failed), len(targets), strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// useTargets sets --targets, --target-policy and target token env vars until the test ends
+func useTargets(t *testing.T, targets, policy string, tokens map[string]string) {
+	plugin.Targets = targets
+	plugin.TargetPolicy = policy
+	for name, value := range tokens {
+		os.Setenv(name, value)
+	}
+	t.Cleanup(func() {
+		for name := range tokens {
+			os.Unsetenv(name)
+		}
+		plugin.Targets = ""
+		plugin.TargetPolicy = ""
+	})
+}
+
+func TestParseTargets(t *testing.T) {
+	region := plugin.APIRegion
+	plugin.APIRegion = "us"
+	defer func() { plugin.APIRegion = region }()
+	targets, err := parseTargets("old=OLD_TOKEN,new=NEW_TOKEN:EU")
+	assert.NoError(t, err)
+	assert.Equal(t, []target{
+		{name: "old", tokenEnv: "OLD_TOKEN", region: "us"},
+		{name: "new", tokenEnv: "NEW_TOKEN", region: "eu"},
+	}, targets)
+
+	invalid := []string{
+		"old",
+		"old=",
+		"old=:eu",
+		"old=OLD_TOKEN:eu:P1",
+		"old=OLD_TOKEN:ap",
+		"../old=OLD_TOKEN",
+		"old=OLD_TOKEN,old=NEW_TOKEN",
+	}
+	for _, v := range invalid {
+		_, err := parseTargets(v)
+		assert.Error(t, err, v)
+	}
+
+	useTargets(t, "old=OLD_TOKEN", "best", nil)
+	assert.Error(t, checkTargets())
+	plugin.TargetPolicy = targetPolicyPrimary
+	assert.NoError(t, checkTargets())
+}
+
+func TestFanOut(t *testing.T) {
+	outbox := useOutbox(t)
+	tokens := map[string]string{"OLD_TOKEN": "old-token", "NEW_TOKEN": "new-token", "LAB_TOKEN": "lab-token"}
+	testCases := []struct {
+		policy        string
+		failing       string
+		expectedError bool
+	}{
+		{targetPolicyAll, "", false},
+		{targetPolicyAll, "new", true},
+		{targetPolicyPrimary, "new", false},
+		{targetPolicyPrimary, "old", true},
+	}
+	for _, tc := range testCases {
+		useTargets(t, "old=OLD_TOKEN,new=NEW_TOKEN,lab=LAB_TOKEN", tc.policy, tokens)
+		targets, err := parseTargets(plugin.Targets)
+		assert.NoError(t, err)
+		err = fanOut(context.Background(), targets, func(ctx context.Context) error {
+			current := currentTarget(ctx)
+			assert.Equal(t, tokens[current.tokenEnv], current.token)
+			assert.Equal(t, filepath.Join(outbox, current.name), current.outboxDir)
+			if current.name == tc.failing {
+				return fmt.Errorf("create alert failed")
+			}
+			return nil
+		})
+		if tc.expectedError {
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), "1 of 3 targets failed: "+tc.failing+": create alert failed")
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	// a target without token fails without calling handle
+	os.Unsetenv("LAB_TOKEN")
+	plugin.TargetPolicy = targetPolicyAll
+	targets, _ := parseTargets(plugin.Targets)
+	err := fanOut(context.Background(), targets, func(ctx context.Context) error {
+		assert.NotEqual(t, "lab", currentTarget(ctx).name)
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "LAB_TOKEN is empty")
+}
+
+func TestFanOutEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	useTargets(t, "old=OLD_TOKEN,new=NEW_TOKEN", targetPolicyAll, map[string]string{"OLD_TOKEN": "old-token", "NEW_TOKEN": "new-token"})
+	plugin.AuthToken = ""
+	event := types.FixtureEvent("entity1", "check1")
+	event.Check.Status = 2
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, executeHandler(event))
+	created, ok := server.Alert("entity1/check1")
+	assert.True(t, ok)
+
+	event.Check.Status = 0
+	assert.NoError(t, executeHandler(event))
+	closed, _ := server.Alert("entity1/check1")
+	assert.Equal(t, created.ID, closed.ID)
+	assert.Equal(t, "closed", closed.Status)
+
+	creates := []string{}
+	for _, request := range server.Requests() {
+		if request.Method == "POST" && request.Path == "/v2/alerts" {
+			creates = append(creates, request.Authorization)
+		}
+	}
+	sort.Strings(creates)
+	assert.Equal(t, []string{"GenieKey new-token", "GenieKey old-token"}, creates)
+}
+
+// go test -race checks that targets match --rules-file concurrently without a data race
+func TestFanOutRulesEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	useTargets(t, "old=OLD_TOKEN,new=NEW_TOKEN,lab=LAB_TOKEN", targetPolicyAll, map[string]string{"OLD_TOKEN": "old-token", "NEW_TOKEN": "new-token", "LAB_TOKEN": "lab-token"})
+	useRules(t, "rules.yaml")
+	plugin.AuthToken = ""
+	event := readFixture(t, "event_from_proxy.json")
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, executeHandler(event))
+	created, ok := server.Alert("k8s.example.com/nodes-ready-k8s-prod")
+	assert.True(t, ok)
+	assert.Equal(t, "P2", created.Priority)
+	assert.Contains(t, created.Tags, "kubernetes")
+	assert.Equal(t, 3, created.Count)
+}
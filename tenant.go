@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu/sensu-go/types"
+	"github.com/spf13/pflag"
+)
+
+// tenantName limits tenant names, they are used as outbox sub directories
+var tenantName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// explicitOptions keeps the arguments of options set on the command line, recorded by main before the handler runs
+var explicitOptions = map[string]bool{}
+
+// tenant represents one entry of --tenant-map
+type tenant struct {
+	name     string
+	tokenEnv string
+	region   string
+	priority alert.Priority
+	teams    []string
+}
+
+// parseTenantMap func parses finance=OPSGENIE_FINANCE_TOKEN:eu:P2:finance-sre:finance-ops,retail=OPSGENIE_RETAIL_TOKEN
+// in a map of tenant by name. Region, priority and teams are optional
+func parseTenantMap(s string) (map[string]tenant, error) {
+	tenants := make(map[string]tenant)
+	for _, v := range splitStringInSlice(s) {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		key, value := splitString(v, "=")
+		name := strings.TrimSpace(key)
+		if name == "" || value == "" {
+			return tenants, fmt.Errorf("tenant map wrong format %q: tenant=TOKEN_ENV_VAR:region:priority:team", v)
+		}
+		if !tenantName.MatchString(name) {
+			return tenants, fmt.Errorf("tenant map invalid tenant %q: use letters, numbers, - and _", name)
+		}
+		if _, ok := tenants[name]; ok {
+			return tenants, fmt.Errorf("tenant map duplicated tenant %q", name)
+		}
+		fields := strings.Split(value, ":")
+		t := tenant{name: name, tokenEnv: fields[0]}
+		if t.tokenEnv == "" {
+			return tenants, fmt.Errorf("tenant map %q should have a token env var", name)
+		}
+		if len(fields) > 1 && fields[1] != "" {
+			t.region = strings.ToLower(fields[1])
+			if t.region != "us" && t.region != "eu" {
+				return tenants, fmt.Errorf("tenant map invalid region %q for %q: use us or eu", fields[1], name)
+			}
+		}
+		if len(fields) > 2 && fields[2] != "" {
+			priority, err := parsePriority(fields[2])
+			if err != nil {
+				return tenants, fmt.Errorf("tenant map invalid priority %q for %q: %s", fields[2], name, err)
+			}
+			t.priority = priority
+		}
+		if len(fields) > 3 {
+			for _, team := range fields[3:] {
+				if team != "" {
+					t.teams = append(t.teams, team)
+				}
+			}
+		}
+		tenants[name] = t
+	}
+	return tenants, nil
+}
+
+// eventTenantName func returns the --tenant-label value of check or entity, check first,
+// and the entity namespace when the label is not found
+func eventTenantName(event *types.Event) string {
+	if plugin.TenantLabel != "" {
+		if event.Check != nil && event.Check.Labels[plugin.TenantLabel] != "" {
+			return event.Check.Labels[plugin.TenantLabel]
+		}
+		if event.Entity != nil && event.Entity.Labels[plugin.TenantLabel] != "" {
+			return event.Entity.Labels[plugin.TenantLabel]
+		}
+	}
+	if event.Entity != nil {
+		return event.Entity.Namespace
+	}
+	return ""
+}
+
+// applyTenant func replaces --auth, --region, --priority, --team and --outbox-dir with the tenant of the event.
+// Events without a tenant in --tenant-map keep the global configuration
+func applyTenant(event *types.Event) error {
+	if plugin.TenantMap == "" {
+		return nil
+	}
+	tenants, err := parseTenantMap(plugin.TenantMap)
+	if err != nil {
+		return err
+	}
+	name := eventTenantName(event)
+	t, ok := tenants[name]
+	if !ok {
+		if len(plugin.AuthToken) == 0 && !plugin.DryRun {
+			return fmt.Errorf("no tenant %q in --tenant-map and authentication token is empty", name)
+		}
+		return nil
+	}
+	return useTenant(t, event)
+}
+
+// useTenant func replaces the global configuration with a tenant. Tenant priority and teams are defaults:
+// they are used only when --priority and --team were not set by flag, env var or annotation of the event, nil without event.
+// A handler run sends one event, so the tenant is used by every OpsGenie call of the run
+func useTenant(t tenant, event *types.Event) error {
+	token := os.Getenv(t.tokenEnv)
+	if token == "" && !plugin.DryRun {
+		return fmt.Errorf("tenant %s: authentication token env var %s is empty", t.name, t.tokenEnv)
+	}
+	fmt.Printf("Using tenant %s \n", t.name)
+	plugin.AuthToken = token
+	if t.region != "" {
+		plugin.APIRegion = t.region
+	}
+	if t.priority != "" && !optionSet("priority", event) {
+		plugin.Priority = string(t.priority)
+	}
+	if len(t.teams) != 0 && !optionSet("team", event) {
+		plugin.Team = strings.Join(t.teams, ",")
+	}
+	// requests of a tenant are replayed with its own token
+	if plugin.OutboxDir != "" {
+		plugin.OutboxDir = filepath.Join(plugin.OutboxDir, t.name)
+	}
+	return nil
+}
+
+// recordExplicitOptions func records the options set in args, like pflag Changed of the handler command
+// which the plugin SDK does not expose. Unknown flags and parse errors are left to the handler command
+func recordExplicitOptions(args []string) {
+	flags := pflag.NewFlagSet("explicit", pflag.ContinueOnError)
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	flags.SetOutput(ioutil.Discard)
+	for _, option := range options {
+		if option.Argument == "" {
+			continue
+		}
+		// only bool flags parse differently, they take no value
+		if _, ok := option.Value.(*bool); ok {
+			flags.BoolP(option.Argument, option.Shorthand, false, "")
+			continue
+		}
+		flags.StringP(option.Argument, option.Shorthand, "", "")
+	}
+	_ = flags.Parse(args)
+	flags.Visit(func(flag *pflag.Flag) {
+		explicitOptions[flag.Name] = true
+	})
+}
+
+// optionSet func returns true if the option with this argument was set on the command line, by its env var
+// or by a check or entity annotation of the event
+func optionSet(argument string, event *types.Event) bool {
+	if explicitOptions[argument] {
+		return true
+	}
+	for _, option := range options {
+		if option.Argument != argument {
+			continue
+		}
+		if option.Env != "" && os.Getenv(option.Env) != "" {
+			return true
+		}
+		if option.Path == "" || event == nil {
+			return false
+		}
+		key := path.Join(plugin.Keyspace, option.Path)
+		if event.Check != nil && event.Check.Annotations[key] != "" {
+			return true
+		}
+		return event.Entity != nil && event.Entity.Annotations[key] != ""
+	}
+	return false
+}
+
+// sortedTenants func returns tenants sorted by name
+func sortedTenants(tenants map[string]tenant) []tenant {
+	sorted := make([]tenant, 0, len(tenants))
+	for _, t := range tenants {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].name < sorted[j].name
+	})
+	return sorted
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// useTenantMap sets --tenant-map and tenant token env vars, the global configuration is restored when the test ends
+func useTenantMap(t *testing.T, tenantMap string, tokens map[string]string) {
+	global := plugin
+	plugin.TenantMap = tenantMap
+	for name, value := range tokens {
+		os.Setenv(name, value)
+	}
+	t.Cleanup(func() {
+		for name := range tokens {
+			os.Unsetenv(name)
+		}
+		plugin = global
+		explicitOptions = map[string]bool{}
+	})
+}
+
+func TestParseTenantMap(t *testing.T) {
+	tenants, err := parseTenantMap("finance=FINANCE_TOKEN:EU:P2:finance-sre:finance-ops,retail=RETAIL_TOKEN,lab=LAB_TOKEN:::lab")
+	assert.NoError(t, err)
+	assert.Len(t, tenants, 3)
+	assert.Equal(t, tenant{name: "finance", tokenEnv: "FINANCE_TOKEN", region: "eu", priority: alert.P2, teams: []string{"finance-sre", "finance-ops"}}, tenants["finance"])
+	assert.Equal(t, tenant{name: "retail", tokenEnv: "RETAIL_TOKEN"}, tenants["retail"])
+	assert.Equal(t, []string{"lab"}, tenants["lab"].teams)
+	assert.Equal(t, []string{"finance", "lab", "retail"}, []string{sortedTenants(tenants)[0].name, sortedTenants(tenants)[1].name, sortedTenants(tenants)[2].name})
+
+	tenants, err = parseTenantMap("")
+	assert.NoError(t, err)
+	assert.Empty(t, tenants)
+
+	invalid := []string{
+		"finance",
+		"finance=",
+		"finance=:eu",
+		"../finance=FINANCE_TOKEN",
+		"finance=FINANCE_TOKEN:ap",
+		"finance=FINANCE_TOKEN:eu:P9",
+		"finance=FINANCE_TOKEN,finance=OTHER_TOKEN",
+	}
+	for _, v := range invalid {
+		_, err := parseTenantMap(v)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestEventTenantName(t *testing.T) {
+	defer func() { plugin.TenantLabel = "" }()
+	event := types.FixtureEvent("entity1", "check1")
+	event.Entity.Namespace = "finance"
+	assert.Equal(t, "finance", eventTenantName(event))
+
+	plugin.TenantLabel = "opsgenie_tenant"
+	assert.Equal(t, "finance", eventTenantName(event))
+	event.Entity.Labels = map[string]string{"opsgenie_tenant": "retail"}
+	assert.Equal(t, "retail", eventTenantName(event))
+	event.Check.Labels = map[string]string{"opsgenie_tenant": "lab"}
+	assert.Equal(t, "lab", eventTenantName(event))
+}
+
+func TestApplyTenant(t *testing.T) {
+	useTenantMap(t, "finance=FINANCE_TOKEN:eu:P2:finance-sre:finance-ops,retail=RETAIL_TOKEN", map[string]string{"FINANCE_TOKEN": "finance-token"})
+	plugin.AuthToken = "global-token"
+	plugin.APIRegion = "us"
+	plugin.Priority = "P3"
+	plugin.Team = ""
+	plugin.OutboxDir = "/var/cache/outbox"
+	event := types.FixtureEvent("entity1", "check1")
+
+	// default namespace has no tenant
+	assert.NoError(t, applyTenant(event))
+	assert.Equal(t, "global-token", plugin.AuthToken)
+
+	event.Entity.Namespace = "finance"
+	assert.NoError(t, applyTenant(event))
+	assert.Equal(t, "finance-token", plugin.AuthToken)
+	assert.Equal(t, "eu", plugin.APIRegion)
+	assert.Equal(t, "P2", plugin.Priority)
+	assert.Equal(t, "finance-sre,finance-ops", plugin.Team)
+	assert.Equal(t, filepath.Join("/var/cache/outbox", "finance"), plugin.OutboxDir)
+
+	// priority and team set by flag, env var or annotation are kept, even with the default value
+	recordExplicitOptions([]string{"--priority", "P3", "--withLabels", "--team=sre"})
+	plugin.Priority = "P3"
+	plugin.Team = "sre"
+	assert.NoError(t, applyTenant(event))
+	assert.Equal(t, "P3", plugin.Priority)
+	assert.Equal(t, "sre", plugin.Team)
+	explicitOptions = map[string]bool{}
+	plugin.Team = ""
+	os.Setenv("OPSGENIE_TEAM", "ops")
+	defer os.Unsetenv("OPSGENIE_TEAM")
+	assert.NoError(t, applyTenant(event))
+	assert.Equal(t, "", plugin.Team)
+	assert.Equal(t, "P2", plugin.Priority)
+	plugin.Priority = "P3"
+	event.Check.Annotations = map[string]string{"sensu.io/plugins/sensu-opsgenie-handler/config/priority": "P3"}
+	assert.NoError(t, applyTenant(event))
+	assert.Equal(t, "P3", plugin.Priority)
+	event.Check.Annotations = nil
+
+	// token env var is not set
+	event.Entity.Namespace = "retail"
+	assert.Error(t, applyTenant(event))
+
+	plugin.AuthToken = ""
+	event.Entity.Namespace = "default"
+	assert.Error(t, applyTenant(event))
+}
+
+func TestRecordExplicitOptions(t *testing.T) {
+	defer func() { explicitOptions = map[string]bool{} }()
+	recordExplicitOptions([]string{"-p", "P3", "--withLabels", "--unknown", "value", "--team="})
+	assert.True(t, explicitOptions["priority"])
+	assert.True(t, explicitOptions["withLabels"])
+	assert.True(t, explicitOptions["team"])
+	assert.False(t, explicitOptions["region"])
+}
+
+func TestTenantMapWithTargets(t *testing.T) {
+	useTenantMap(t, "finance=FINANCE_TOKEN", nil)
+	plugin.Targets = "old=OPSGENIE_AUTHTOKEN,new=OPSGENIE_NEW_TOKEN"
+	event := types.FixtureEvent("entity1", "check1")
+	assert.Error(t, checkArgs(event))
+	plugin.OutboxDir = "/var/cache/outbox"
+	_, err := checkFlushArgs(event)
+	assert.Error(t, err)
+	plugin.OutboxDir = ""
+	plugin.Targets = ""
+	assert.NoError(t, checkArgs(event))
+}
+
+func TestTenantEndToEnd(t *testing.T) {
+	server := useMockServer(t)
+	useTenantMap(t, "finance=FINANCE_TOKEN::P2:finance-sre", map[string]string{"FINANCE_TOKEN": "finance-token"})
+	event := types.FixtureEvent("entity1", "check1")
+	event.Entity.Namespace = "finance"
+	event.Check.Status = 2
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, executeHandler(event))
+	created, ok := server.Alert("entity1/check1")
+	assert.True(t, ok)
+	assert.Equal(t, "P2", created.Priority)
+	assert.Len(t, created.Responders, 1)
+	assert.Equal(t, "finance-sre", created.Responders[0].Name)
+	requests := server.Requests()
+	assert.Equal(t, "GenieKey finance-token", requests[len(requests)-1].Authorization)
+}
+
+func TestFlushTenants(t *testing.T) {
+	server := useMockServer(t)
+	dir := useOutbox(t)
+	useTenantMap(t, "finance=FINANCE_TOKEN,retail=RETAIL_TOKEN", map[string]string{"FINANCE_TOKEN": "finance-token"})
+	plugin.AuthToken = ""
+
+	plugin.OutboxDir = filepath.Join(dir, "finance")
+	assert.NoError(t, os.MkdirAll(plugin.OutboxDir, 0700))
+	assert.NoError(t, enqueueOutbox(context.Background(), outboxCreate, "entity1/check1", &alert.CreateAlertRequest{Message: "entity1/check1", Alias: "entity1/check1"}))
+	plugin.OutboxDir = dir
+
+	status, err := checkFlushArgs(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, sensu.CheckStateOK, status)
+	status, err = executeFlush(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, sensu.CheckStateOK, status)
+	_, ok := server.Alert("entity1/check1")
+	assert.True(t, ok)
+	for _, request := range server.Requests() {
+		assert.Equal(t, "GenieKey finance-token", request.Authorization)
+	}
+	assert.Equal(t, dir, plugin.OutboxDir)
+}
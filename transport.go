@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// apiBaseURL func returns --api-url, or the --backend base URL when it is empty, if it is a full URL with scheme.
+// Host only values return false and keep the OpsGenie SDK behaviour
+func apiBaseURL() (*url.URL, bool, error) {
+	apiURL := plugin.APIURL
+	if apiURL == "" {
+		apiURL = backendBaseURL()
+	}
+	if !strings.Contains(apiURL, "://") {
+		return nil, false, nil
+	}
+	base, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid --api-url: %s", err)
+	}
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, false, fmt.Errorf("invalid --api-url %s: expected http(s)://host[:port][/path]", apiURL)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	base.RawPath = ""
+	return base, true, nil
+}
+
+// tlsConfig func returns the TLS configuration with --ca-bundle added to system roots
+// and the --client-cert and --client-key pair for mutual TLS
+func tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if plugin.CABundle != "" {
+		content, err := ioutil.ReadFile(plugin.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read --ca-bundle: %s", err)
+		}
+		// SystemCertPool is not available on every platform, the bundle alone is used then
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no PEM certificates found in --ca-bundle %s", plugin.CABundle)
+		}
+		config.RootCAs = pool
+	}
+	if plugin.ClientCert != "" || plugin.ClientKey != "" {
+		if plugin.ClientCert == "" || plugin.ClientKey == "" {
+			return nil, fmt.Errorf("--client-cert and --client-key must be used together")
+		}
+		cert, err := tls.LoadX509KeyPair(plugin.ClientCert, plugin.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load --client-cert and --client-key: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// httpClient func returns the HTTP client shared by alert and heartbeat clients
+// with --proxy-url, TLS options and --api-url base URL applied
+func httpClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if plugin.ProxyURL != "" {
+		proxy, err := url.Parse(plugin.ProxyURL)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid --proxy-url: expected scheme://[user:password@]host:port")
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	config, err := tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = config
+	var roundTripper http.RoundTripper = transport
+	base, ok, err := apiBaseURL()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		roundTripper = &baseURLTransport{base: base, next: roundTripper}
+	}
+	return &http.Client{Transport: roundTripper}, nil
+}
+
+// baseURLTransport sends requests built by the OpsGenie SDK to the scheme, host and path prefix of --api-url
+type baseURLTransport struct {
+	base *url.URL
+	next http.RoundTripper
+}
+
+// RoundTrip func rewrites the request URL and sends it with the next transport
+func (t *baseURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	out.URL.Scheme = t.base.Scheme
+	out.URL.Host = t.base.Host
+	out.URL.Path = t.base.Path + req.URL.Path
+	if req.URL.RawPath != "" {
+		out.URL.RawPath = t.base.EscapedPath() + req.URL.RawPath
+	}
+	out.Host = t.base.Host
+	return t.next.RoundTrip(out)
+}
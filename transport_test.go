@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// useTransport resets transport options when the test ends
+func useTransport(t *testing.T) {
+	t.Cleanup(func() {
+		plugin.ProxyURL = ""
+		plugin.CABundle = ""
+		plugin.ClientCert = ""
+		plugin.ClientKey = ""
+	})
+}
+
+// writePEM writes a PEM block in dir and returns its path
+func writePEM(t *testing.T, dir, name, blockType string, content []byte) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: content}), 0600))
+	return path
+}
+
+// clientCertificate creates a self-signed client certificate and returns it with its cert and key files
+func clientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sensu-opsgenie-handler"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return cert, writePEM(t, dir, "client.crt", "CERTIFICATE", der), writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+}
+
+func TestAPIBaseURL(t *testing.T) {
+	region := plugin.APIRegion
+	plugin.APIRegion = "us"
+	defer func() {
+		plugin.APIURL = ""
+		plugin.APIRegion = region
+	}()
+	testCases := []struct {
+		apiURL        string
+		expected      string
+		expectedHost  string
+		expectedError bool
+	}{
+		{"", "", "api.opsgenie.com", false},
+		{"api.sandbox.opsgenie.com", "", "api.sandbox.opsgenie.com", false},
+		{"127.0.0.1:8080", "", "127.0.0.1:8080", false},
+		{"https://gateway.example.com/opsgenie/", "https://gateway.example.com/opsgenie", "gateway.example.com", false},
+		{"http://127.0.0.1:8080", "http://127.0.0.1:8080", "127.0.0.1:8080", false},
+		{"ftp://gateway.example.com", "", "", true},
+		{"https:///opsgenie", "", "", true},
+	}
+	for _, tc := range testCases {
+		plugin.APIURL = tc.apiURL
+		base, ok, err := apiBaseURL()
+		if tc.expectedError {
+			assert.Error(t, err, tc.apiURL)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected != "", ok)
+		if ok {
+			assert.Equal(t, tc.expected, base.String())
+		}
+		assert.Equal(t, tc.expectedHost, string(switchOpsgenieRegion()))
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	useTransport(t)
+	dir := t.TempDir()
+	_, certFile, keyFile := clientCertificate(t, dir)
+	invalid := filepath.Join(dir, "invalid.pem")
+	assert.NoError(t, ioutil.WriteFile(invalid, []byte("not a certificate"), 0600))
+	testCases := []struct {
+		caBundle   string
+		clientCert string
+		clientKey  string
+		expected   string
+	}{
+		{filepath.Join(dir, "missing.pem"), "", "", "cannot read --ca-bundle"},
+		{invalid, "", "", "no PEM certificates found"},
+		{"", certFile, "", "must be used together"},
+		{"", certFile, invalid, "cannot load --client-cert"},
+	}
+	for _, tc := range testCases {
+		plugin.CABundle = tc.caBundle
+		plugin.ClientCert = tc.clientCert
+		plugin.ClientKey = tc.clientKey
+		_, err := tlsConfig()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), tc.expected)
+	}
+	plugin.CABundle = certFile
+	plugin.ClientKey = keyFile
+	config, err := tlsConfig()
+	assert.NoError(t, err)
+	assert.NotNil(t, config.RootCAs)
+	assert.Len(t, config.Certificates, 1)
+}
+
+func TestBaseURLPathPrefix(t *testing.T) {
+	server := useMockServer(t)
+	gateway := httptest.NewServer(http.StripPrefix("/opsgenie", server.Handler()))
+	defer gateway.Close()
+	plugin.APIURL = gateway.URL + "/opsgenie/"
+	event := types.FixtureEvent("entity1", "check1")
+	event.Check.Status = 2
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, executeHandler(event))
+	created, ok := server.Alert("entity1/check1")
+	assert.True(t, ok)
+	assert.Equal(t, "open", created.Status)
+}
+
+func TestCABundleAndClientCert(t *testing.T) {
+	server := useMockServer(t)
+	useTransport(t)
+	dir := t.TempDir()
+	clientCert, certFile, keyFile := clientCertificate(t, dir)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	gateway := httptest.NewUnstartedServer(server.Handler())
+	gateway.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	// silence TLS handshake errors expected by this test
+	gateway.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	gateway.StartTLS()
+	defer gateway.Close()
+	plugin.APIURL = gateway.URL
+	event := types.FixtureEvent("entity1", "check1")
+	event.Check.Status = 2
+
+	// server certificate is not trusted without --ca-bundle
+	assert.Error(t, executeHandler(event))
+
+	plugin.CABundle = writePEM(t, dir, "ca.pem", "CERTIFICATE", gateway.Certificate().Raw)
+	// client certificate is required by the server
+	assert.Error(t, executeHandler(event))
+	_, ok := server.Alert("entity1/check1")
+	assert.False(t, ok)
+
+	plugin.ClientCert = certFile
+	plugin.ClientKey = keyFile
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, executeHandler(event))
+	_, ok = server.Alert("entity1/check1")
+	assert.True(t, ok)
+}
+
+func TestProxyURL(t *testing.T) {
+	server := useMockServer(t)
+	useTransport(t)
+	var (
+		mu            sync.Mutex
+		proxiedHosts  []string
+		authorization string
+	)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxiedHosts = append(proxiedHosts, r.URL.Host)
+		authorization = r.Header.Get("Proxy-Authorization")
+		mu.Unlock()
+		server.Handler().ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+	// the host is never resolved, requests only reach the proxy
+	plugin.APIURL = "opsgenie.invalid:80"
+	plugin.ProxyURL = "http://user:secret@" + proxy.Listener.Addr().String()
+	event := types.FixtureEvent("entity1", "check1")
+	event.Check.Status = 2
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, executeHandler(event))
+	_, ok := server.Alert("entity1/check1")
+	assert.True(t, ok)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NotEmpty(t, proxiedHosts)
+	assert.Equal(t, "opsgenie.invalid:80", proxiedHosts[0])
+	// user:secret
+	assert.Equal(t, "Basic dXNlcjpzZWNyZXQ=", authorization)
+
+	plugin.ProxyURL = "://invalid"
+	assert.Error(t, checkArgs(event))
+}
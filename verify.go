@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+)
+
+// verifyInterval is the wait between two request status lookups, tests can replace it
+var verifyInterval = 500 * time.Millisecond
+
+// verifyRequest func polls OpsGenie request status until the asynchronous request succeeds or fails.
+// OpsGenie answers 404 until the request is processed, so 404 and retryable errors are polled again until ctx is done
+func verifyRequest(ctx context.Context, alertClient AlertAPI, operation, requestID string) error {
+	for {
+		var status *alert.RequestStatusResult
+		err := callWithTimeout(ctx, func(ctx context.Context) (err error) {
+			status, err = alertClient.GetRequestStatus(ctx, &alert.GetRequestStatusRequest{RequestId: requestID})
+			return err
+		})
+		switch {
+		case err == nil && status.IsSuccess:
+			fmt.Printf("Request %s to %s succeeded: %s, alert ID: %s \n", requestID, operation, status.Status, status.AlertID)
+			return nil
+		case err == nil:
+			return fmt.Errorf("%s failed in OpsGenie, request %s: %s", operation, requestID, status.Status)
+		case !isNotFound(err) && !isRetryable(err):
+			return fmt.Errorf("cannot verify %s, request %s: %w", operation, requestID, err)
+		}
+		select {
+		case <-ctx.Done():
+			return deadlineError(fmt.Sprintf("verification of %s request %s", operation, requestID), ctx.Err())
+		case <-time.After(verifyInterval):
+		}
+	}
+}
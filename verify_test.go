@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
+	"github.com/opsgenie/opsgenie-go-sdk-v2/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyRequest(t *testing.T) {
+	verifyInterval = time.Millisecond
+	defer func() { verifyInterval = 500 * time.Millisecond }()
+	alertAPI := &fakeAlertAPI{}
+	ctx := context.Background()
+	assert.NoError(t, verifyRequest(ctx, alertAPI, "create alert", "request-id"))
+	assert.Equal(t, []string{"GetRequestStatus request-id"}, alertAPI.calls)
+
+	// OpsGenie side failure is returned with its message
+	alertAPI.requestStatus = &alert.RequestStatusResult{IsSuccess: false, Status: "Invalid responder"}
+	err := verifyRequest(ctx, alertAPI, "create alert", "request-id")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid responder")
+
+	// auth errors stop polling
+	failing := &failingStatusAPI{err: &client.ApiError{StatusCode: 401}}
+	assert.Error(t, verifyRequest(ctx, failing, "create alert", "request-id"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&failing.calls))
+
+	// pending requests are polled until the deadline
+	pending := &failingStatusAPI{err: &client.ApiError{StatusCode: 404}}
+	deadline, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = verifyRequest(deadline, pending, "close alert", "request-id")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "verification of close alert request request-id")
+	assert.Greater(t, atomic.LoadInt32(&pending.calls), int32(1))
+}
+
+// failingStatusAPI returns err from every GetRequestStatus, calls can be counted after the call is abandoned
+type failingStatusAPI struct {
+	fakeAlertAPI
+	err   error
+	calls int32
+}
+
+func (f *failingStatusAPI) GetRequestStatus(ctx context.Context, req *alert.GetRequestStatusRequest) (*alert.RequestStatusResult, error) {
+	atomic.AddInt32(&f.calls, 1)
+	return nil, f.err
+}